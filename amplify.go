@@ -0,0 +1,56 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"strconv"
+)
+
+// amplify returns the copies of the request to be sent to the load test client by the amplification factor,
+// the keys of the extra copies are suffixed with AmplificationKeySuffix and the copy number if it is set.
+func (c *connectorImpl) amplify(client *clientImpl, req *loadTestRequest) []*loadTestRequest {
+	factor := client.config.AmplificationFactor
+	// publishes aren't amplified, the merged subscribers would receive the copies as duplicate messages
+	if factor <= 1 || req.function == tagFunctionPublish {
+		return []*loadTestRequest{req}
+	}
+
+	suffix := client.config.AmplificationKeySuffix
+	copies := make([]*loadTestRequest, factor)
+	copies[0] = req
+	for i := 1; i < factor; i++ {
+		if suffix == "" {
+			copies[i] = req
+			continue
+		}
+		copySuffix := suffix + strconv.Itoa(i)
+		copies[i] = c.rewriteKeys(req, func(key string) string {
+			return key + copySuffix
+		})
+	}
+	return copies
+}
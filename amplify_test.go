@@ -0,0 +1,95 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	goredis "github.com/grab/redis/v8"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/grab/grab-redis/redisapi"
+)
+
+var _ = Describe("Test Amplification", func() {
+	var c *connectorImpl
+	var loadTest *clientImpl
+
+	BeforeEach(func() {
+		c = &connectorImpl{}
+		c.routing.Store(&routing{
+			client: &clientImpl{
+				cmdCache: map[string]*goredis.CommandInfo{
+					"set":  {Name: "set", FirstKeyPos: 1, LastKeyPos: 1, StepCount: 1},
+					"mset": {Name: "mset", FirstKeyPos: 1, LastKeyPos: -1, StepCount: 2},
+					"ping": {Name: "ping"},
+				},
+			},
+		})
+		loadTest = &clientImpl{config: &ClientConfig{AmplificationFactor: 3, AmplificationKeySuffix: ":copy"}}
+	})
+
+	It("sends the request once when the factor is 1", func() {
+		loadTest.config.AmplificationFactor = 1
+		req := newDoRequest("SET", []interface{}{"k", "v"})
+		Expect(c.amplify(loadTest, req)).To(Equal([]*loadTestRequest{req}))
+	})
+
+	It("doesn't amplify publishes", func() {
+		req := newPublishRequest("channel", "message")
+		Expect(c.amplify(loadTest, req)).To(Equal([]*loadTestRequest{req}))
+	})
+
+	It("sends the same request N times without key suffix", func() {
+		loadTest.config.AmplificationKeySuffix = ""
+		req := newDoRequest("SET", []interface{}{"k", "v"})
+		Expect(c.amplify(loadTest, req)).To(Equal([]*loadTestRequest{req, req, req}))
+	})
+
+	It("suffixes the keys of the extra copies", func() {
+		req := newPipelineRequest([][]interface{}{
+			{"MSET", "{a}:1", "v1", "{a}:2", "v2"},
+			{"PING"},
+		})
+		copies := c.amplify(loadTest, req)
+		Expect(copies).To(HaveLen(3))
+		Expect(copies[0]).To(Equal(req))
+		Expect(copies[1].cmds).To(Equal([][]interface{}{
+			{"MSET", "{a}:1:copy1", "v1", "{a}:2:copy1", "v2"},
+			{"PING"},
+		}))
+		Expect(copies[2].cmds[0]).To(Equal([]interface{}{"MSET", "{a}:1:copy2", "v1", "{a}:2:copy2", "v2"}))
+		Expect(req.cmds[0]).To(Equal([]interface{}{"MSET", "{a}:1", "v1", "{a}:2", "v2"}))
+	})
+
+	It("suffixes the keys of a script", func() {
+		script := redisapi.NewScript(1, "return 1")
+		req := newRunRequest(script, []interface{}{"k", "arg"})
+		copies := c.amplify(loadTest, req)
+		Expect(copies[1].keysAndArgs).To(Equal([]interface{}{"k:copy1", "arg"}))
+		Expect(copies[1].script).To(Equal(script))
+	})
+})
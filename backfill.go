@@ -0,0 +1,551 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+
+	"github.com/grab/grab-redis/redisapi"
+	goredis "github.com/grab/redis/v8"
+)
+
+// Backfiller copies the existing keys of every master node of the source to the target
+type Backfiller struct {
+	config  *BackfillConfig
+	source  *clientImpl
+	target  *clientImpl
+	limiter *tokenBucket
+
+	// dumpBlocked is set when DUMP/RESTORE fails in BackfillAuto, the rest of the keys are copied by types
+	dumpBlocked *atomic.Bool
+
+	scanned *atomic.Int64
+	copied  *atomic.Int64
+	skipped *atomic.Int64
+	failed  *atomic.Int64
+
+	mu            sync.Mutex
+	cursors       map[string]*backfillCursor
+	lastSavedTime time.Time
+
+	stats  StatsClient
+	logger Logger
+}
+
+// BackfillProgress is the number of keys processed by the Backfiller
+type BackfillProgress struct {
+	Scanned int64
+	Copied  int64
+	Skipped int64
+	Failed  int64
+}
+
+// backfillCursor is the SCAN cursor of a node, the node is done when the SCAN returns 0
+type backfillCursor struct {
+	Cursor uint64 `json:"cursor"`
+	Done   bool   `json:"done"`
+}
+
+// NewBackfiller creates the source and target clients of the backfill, the options are applied to both clients.
+func NewBackfiller(ctx context.Context, config *BackfillConfig, options ...ClientOption) (*Backfiller, error) {
+	config.init()
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	source, err := newClient(ctx, config.Source, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := newClient(ctx, config.Target, options...)
+	if err != nil {
+		source.ShutDown(ctx)
+		return nil, err
+	}
+
+	b := &Backfiller{
+		config:      config,
+		source:      source,
+		target:      target,
+		limiter:     newTokenBucket(float64(config.MaxKeysPerSecond), config.ScanCount),
+		dumpBlocked: atomic.NewBool(config.Mode == BackfillTyped),
+		scanned:     atomic.NewInt64(0),
+		copied:      atomic.NewInt64(0),
+		skipped:     atomic.NewInt64(0),
+		failed:      atomic.NewInt64(0),
+		cursors:     make(map[string]*backfillCursor),
+		stats:       source.stats,
+		logger:      source.logger,
+	}
+
+	if err = b.loadCursors(); err != nil {
+		b.ShutDown(ctx)
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Run scans all the master nodes of the source concurrently and copies the keys to the target, it returns when all the nodes are done.
+// If CursorFile is set, the next Run continues from the saved cursors.
+func (b *Backfiller) Run(ctx context.Context) error {
+	stop := make(chan struct{})
+	go b.monitorProgress(reportInterval, stop)
+	defer close(stop)
+
+	err := b.source.wrappedClient.forEachMaster(ctx, b.backfillNode)
+	if saveErr := b.saveCursors(); err == nil {
+		err = saveErr
+	}
+	b.reportProgress()
+
+	return err
+}
+
+// Progress returns the number of keys processed so far
+func (b *Backfiller) Progress() BackfillProgress {
+	return BackfillProgress{
+		Scanned: b.scanned.Load(),
+		Copied:  b.copied.Load(),
+		Skipped: b.skipped.Load(),
+		Failed:  b.failed.Load(),
+	}
+}
+
+// ShutDown closes the source and target clients
+func (b *Backfiller) ShutDown(ctx context.Context) {
+	b.source.ShutDown(ctx)
+	b.target.ShutDown(ctx)
+}
+
+func (b *Backfiller) backfillNode(ctx context.Context, node *goredis.Client) error {
+	addr := node.Options().Addr
+	cursor := b.cursor(addr)
+	if cursor.Done {
+		return nil
+	}
+
+	for {
+		keys, next, err := node.Scan(ctx, cursor.Cursor, b.config.Match, int64(b.config.ScanCount)).Result()
+		if err != nil {
+			return errors.Wrapf(err, "backfill scan on node %s failed", addr)
+		}
+		b.scanned.Add(int64(len(keys)))
+
+		if err = b.copyKeys(ctx, node, keys); err != nil {
+			return err
+		}
+
+		cursor = &backfillCursor{Cursor: next, Done: next == 0}
+		b.setCursor(addr, cursor)
+		if cursor.Done {
+			b.logger.Info(pkgName, "backfill of node %s is done", addr)
+			return nil
+		}
+	}
+}
+
+func (b *Backfiller) copyKeys(ctx context.Context, node *goredis.Client, keys []string) error {
+	for range keys {
+		if err := b.limiter.wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	if !b.dumpBlocked.Load() {
+		keys = b.dumpAndRestore(ctx, node, keys)
+	}
+
+	for _, key := range keys {
+		if err := b.typedCopy(ctx, node, key); err != nil {
+			b.failed.Inc()
+			b.logger.Warn(pkgName, "backfill of key %s failed, Error: %s", key, err)
+		}
+	}
+
+	return nil
+}
+
+// dumpAndRestore copies the keys with DUMP/RESTORE and returns the keys need to be copied by types in BackfillAuto
+func (b *Backfiller) dumpAndRestore(ctx context.Context, node *goredis.Client, keys []string) []string {
+	pipe := node.Pipeline()
+	dumps := make([]*goredis.Cmd, len(keys))
+	ttls := make([]*goredis.Cmd, len(keys))
+	for i, key := range keys {
+		dumps[i] = pipe.Do(ctx, "DUMP", key)
+		ttls[i] = pipe.Do(ctx, "PTTL", key)
+	}
+	_, _ = pipe.Exec(ctx)
+
+	var fallbackKeys, restoreKeys []string
+	var argsList [][]interface{}
+	for i, key := range keys {
+		dump, err := dumps[i].Text()
+		if err == goredis.Nil {
+			// the key is deleted or expired after SCAN
+			b.skipped.Inc()
+			continue
+		}
+		if err != nil {
+			fallbackKeys = b.handleDumpError(fallbackKeys, key, err)
+			continue
+		}
+
+		ttl, err := ttls[i].Int64()
+		if err != nil {
+			b.failed.Inc()
+			b.logger.Warn(pkgName, "backfill PTTL of key %s failed, Error: %s", key, err)
+			continue
+		}
+		if ttl == -2 {
+			b.skipped.Inc()
+			continue
+		}
+		if ttl < 0 {
+			ttl = 0
+		}
+
+		args := []interface{}{"RESTORE", b.config.Target.rewriteKey(key), ttl, dump}
+		if b.config.Replace {
+			args = append(args, "REPLACE")
+		}
+		argsList = append(argsList, args)
+		restoreKeys = append(restoreKeys, key)
+	}
+
+	if len(argsList) == 0 {
+		return fallbackKeys
+	}
+
+	replies, _ := b.target.Pipeline(ctx, argsList)
+	for i, reply := range replies {
+		switch {
+		case reply.Err == nil:
+			b.copied.Inc()
+		case strings.HasPrefix(reply.Err.Error(), redisErrBusyKey):
+			b.skipped.Inc()
+		default:
+			fallbackKeys = b.handleDumpError(fallbackKeys, restoreKeys[i], reply.Err)
+		}
+	}
+
+	return fallbackKeys
+}
+
+// handleDumpError switches to copying by types in BackfillAuto, DUMP/RESTORE could be disabled or the RDB version could be incompatible
+func (b *Backfiller) handleDumpError(fallbackKeys []string, key string, err error) []string {
+	if b.config.Mode != BackfillAuto {
+		b.failed.Inc()
+		b.logger.Warn(pkgName, "backfill DUMP/RESTORE of key %s failed, Error: %s", key, err)
+		return fallbackKeys
+	}
+
+	if b.dumpBlocked.CAS(false, true) {
+		b.logger.Warn(pkgName, "backfill DUMP/RESTORE failed, copying the keys by types instead, Error: %s", err)
+	}
+	return append(fallbackKeys, key)
+}
+
+// typedCopy reads the key by its type and writes it to the target, the key is skipped if it exists in the target and Replace
+// is false. The value is written to a temp key and renamed to the key, so a key written by dual write in the meantime is
+// neither overwritten nor merged with the copy.
+func (b *Backfiller) typedCopy(ctx context.Context, node *goredis.Client, key string) error {
+	ttl, err := node.Do(ctx, "PTTL", key).Int64()
+	if err != nil {
+		return err
+	}
+	if ttl == -2 {
+		b.skipped.Inc()
+		return nil
+	}
+
+	keyType, value, err := readKey(ctx, nodeDoer(node), key)
+	if err != nil {
+		return err
+	}
+	if keyType == "none" {
+		b.skipped.Inc()
+		return nil
+	}
+
+	// the key is written to the target by the key rewrite rules of the target
+	targetKey := b.config.Target.rewriteKey(key)
+	if keyType == "string" {
+		return b.copyString(ctx, targetKey, value, ttl)
+	}
+
+	tempKey, ok := backfillTempKey(targetKey)
+	if !ok {
+		return errors.Errorf("no temp key in the same slot as key %s", targetKey)
+	}
+	argsList := [][]interface{}{{"DEL", tempKey}}
+	switch keyType {
+	case "hash":
+		argsList = appendChunks(argsList, "HSET", tempKey, value.([]interface{}))
+	case "list":
+		argsList = appendChunks(argsList, "RPUSH", tempKey, value.([]interface{}))
+	case "set":
+		argsList = appendChunks(argsList, "SADD", tempKey, value.([]interface{}))
+	case "zset":
+		values := value.([]interface{})
+		// ZRANGE replies member, score while ZADD takes score, member
+		for i := 0; i+1 < len(values); i += 2 {
+			values[i], values[i+1] = values[i+1], values[i]
+		}
+		argsList = appendChunks(argsList, "ZADD", tempKey, values)
+	}
+
+	if ttl > 0 {
+		argsList = append(argsList, []interface{}{"PEXPIRE", tempKey, ttl})
+	}
+
+	if _, err = b.target.Pipeline(ctx, argsList); err != nil {
+		_, _ = b.target.Do(ctx, "DEL", tempKey)
+		return err
+	}
+
+	if b.config.Replace {
+		_, err = b.target.Do(ctx, "RENAME", tempKey, targetKey)
+		if err == nil {
+			b.copied.Inc()
+		}
+		return err
+	}
+
+	renamed, err := b.target.Do(ctx, "RENAMENX", tempKey, targetKey)
+	if err != nil {
+		return err
+	}
+	if n, ok := renamed.(int64); ok && n == 0 {
+		// the key is written by dual write after the copy is read
+		_, _ = b.target.Do(ctx, "DEL", tempKey)
+		b.skipped.Inc()
+		return nil
+	}
+	b.copied.Inc()
+	return nil
+}
+
+// copyString writes the string key with SET NX unless Replace is set, a key existing in the target is skipped
+func (b *Backfiller) copyString(ctx context.Context, targetKey string, value interface{}, ttl int64) error {
+	args := []interface{}{targetKey, value}
+	if ttl > 0 {
+		args = append(args, "PX", ttl)
+	}
+	if !b.config.Replace {
+		args = append(args, "NX")
+	}
+
+	reply, err := b.target.Do(ctx, "SET", args...)
+	if err != nil {
+		return err
+	}
+	if reply == nil {
+		b.skipped.Inc()
+		return nil
+	}
+	b.copied.Inc()
+	return nil
+}
+
+// backfillTempKey returns the temp key of a typed copy, it's in the same slot as the key so it can be renamed to the key.
+// A key with '}' but without a hash tag can't be wrapped in a hash tag.
+func backfillTempKey(key string) (string, bool) {
+	if _, ok := hashTag(key); ok {
+		return key + backfillTempKeySuffix, true
+	}
+	if strings.IndexByte(key, '}') >= 0 {
+		return "", false
+	}
+	return "{" + key + "}" + backfillTempKeySuffix, true
+}
+
+// keyDoer sends a cmd to a node or a client, a nil reply is returned as nil value without error
+type keyDoer func(ctx context.Context, args ...interface{}) (interface{}, error)
+
+func nodeDoer(node *goredis.Client) keyDoer {
+	return func(ctx context.Context, args ...interface{}) (interface{}, error) {
+		value, err := node.Do(ctx, args...).Result()
+		if err == goredis.Nil {
+			err = nil
+		}
+		return value, err
+	}
+}
+
+func clientDoer(client *clientImpl) keyDoer {
+	return func(ctx context.Context, args ...interface{}) (interface{}, error) {
+		return client.do(ctx, args...)
+	}
+}
+
+// readKey reads the type and the value of a key, the value is a string for string keys,
+// and the []interface{} reply of HGETALL, LRANGE, SMEMBERS and ZRANGE WITHSCORES for the others.
+// The type is "none" if the key doesn't exist.
+func readKey(ctx context.Context, do keyDoer, key string) (string, interface{}, error) {
+	keyType, err := redisapi.String(do(ctx, "TYPE", key))
+	if err != nil {
+		return "", nil, err
+	}
+
+	var value interface{}
+	switch keyType {
+	case "none":
+		return keyType, nil, nil
+	case "string":
+		value, err = do(ctx, "GET", key)
+	case "hash":
+		value, err = do(ctx, "HGETALL", key)
+	case "list":
+		value, err = do(ctx, "LRANGE", key, 0, -1)
+	case "set":
+		value, err = do(ctx, "SMEMBERS", key)
+	case "zset":
+		value, err = do(ctx, "ZRANGE", key, 0, -1, "WITHSCORES")
+	default:
+		return keyType, nil, errors.Errorf("type %s is not supported", keyType)
+	}
+	if err != nil {
+		return keyType, nil, err
+	}
+
+	// the key is deleted or expired after TYPE
+	if value == nil {
+		return "none", nil, nil
+	}
+	if keyType != "string" {
+		if _, ok := value.([]interface{}); !ok {
+			return keyType, nil, errors.Errorf("unexpected reply type %T for %s key", value, keyType)
+		}
+	}
+
+	return keyType, value, nil
+}
+
+// appendChunks splits the values into multiple cmds to avoid a huge cmd for a big key, the chunk size is even so the pairs are kept
+func appendChunks(argsList [][]interface{}, cmdName string, key string, values []interface{}) [][]interface{} {
+	for start := 0; start < len(values); start += backfillChunkSize {
+		end := start + backfillChunkSize
+		if end > len(values) {
+			end = len(values)
+		}
+		args := append([]interface{}{cmdName, key}, values[start:end]...)
+		argsList = append(argsList, args)
+	}
+	return argsList
+}
+
+func (b *Backfiller) cursor(addr string) *backfillCursor {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if cursor, ok := b.cursors[addr]; ok {
+		return cursor
+	}
+	return &backfillCursor{}
+}
+
+func (b *Backfiller) setCursor(addr string, cursor *backfillCursor) {
+	b.mu.Lock()
+	b.cursors[addr] = cursor
+	shouldSave := time.Since(b.lastSavedTime) >= backfillCursorSaveInterval
+	b.mu.Unlock()
+
+	if shouldSave {
+		if err := b.saveCursors(); err != nil {
+			b.logger.Warn(pkgName, "unable to save backfill cursors, Error: %s", err)
+		}
+	}
+}
+
+func (b *Backfiller) loadCursors() error {
+	if b.config.CursorFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(b.config.CursorFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to read backfill cursor file")
+	}
+
+	return json.Unmarshal(data, &b.cursors)
+}
+
+func (b *Backfiller) saveCursors() error {
+	if b.config.CursorFile == "" {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, err := json.Marshal(b.cursors)
+	if err != nil {
+		return err
+	}
+
+	// write to a temp file and rename it, so a crash won't leave a broken cursor file
+	tmpFile := b.config.CursorFile + ".tmp"
+	if err = os.WriteFile(tmpFile, data, 0o644); err != nil {
+		return err
+	}
+	b.lastSavedTime = time.Now()
+	return os.Rename(tmpFile, b.config.CursorFile)
+}
+
+func (b *Backfiller) monitorProgress(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.reportProgress()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (b *Backfiller) reportProgress() {
+	progress := b.Progress()
+	tags := b.source.getTags()
+	b.stats.Gauge("redis.backfill", metricScanned, float64(progress.Scanned), tags)
+	b.stats.Gauge("redis.backfill", metricCopied, float64(progress.Copied), tags)
+	b.stats.Gauge("redis.backfill", metricSkipped, float64(progress.Skipped), tags)
+	b.stats.Gauge("redis.backfill", metricFailed, float64(progress.Failed), tags)
+}
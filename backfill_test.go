@@ -0,0 +1,201 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	goredis "github.com/grab/redis/v8"
+)
+
+func backfillConfig(mode BackfillMode) *BackfillConfig {
+	return &BackfillConfig{
+		Source: &ClientConfig{
+			ClientMode: ModeCluster,
+			Addrs:      []string{mainClusterAddr},
+			PoolSize:   10,
+		},
+		Target: &ClientConfig{
+			ClientMode: ModeCluster,
+			Addrs:      []string{loadTestAddr},
+			PoolSize:   10,
+		},
+		Mode: mode,
+	}
+}
+
+var _ = Describe("Test Backfiller", func() {
+	var source *clientImpl
+	var target *clientImpl
+
+	BeforeEach(func() {
+		var err error
+		source, err = newClient(context.Background(), clusterConfig().Main)
+		Expect(err).NotTo(HaveOccurred())
+		target, err = newClient(context.Background(), clusterConfig().LoadTests[0])
+		Expect(err).NotTo(HaveOccurred())
+
+		_ = source.wrappedClient.forEachMaster(context.Background(), func(ctx context.Context, client *goredis.Client) error {
+			return client.FlushAll(ctx).Err()
+		})
+		_ = target.wrappedClient.forEachMaster(context.Background(), func(ctx context.Context, client *goredis.Client) error {
+			return client.FlushAll(ctx).Err()
+		})
+
+		_, err = source.Pipeline(context.Background(), [][]interface{}{
+			{"SET", "string", "value", "PX", 100000},
+			{"HSET", "hash", "f1", "v1", "f2", "v2"},
+			{"RPUSH", "list", "a", "b", "c"},
+			{"SADD", "set", "a", "b"},
+			{"ZADD", "zset", 1, "a", 2.5, "b"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		source.ShutDown(context.Background())
+		target.ShutDown(context.Background())
+	})
+
+	validate := func() {
+		Expect(target.Do(context.Background(), "GET", "string")).To(Equal("value"))
+		Expect(target.Do(context.Background(), "PTTL", "string")).To(BeNumerically(">", 0))
+		Expect(target.Do(context.Background(), "HGET", "hash", "f2")).To(Equal("v2"))
+		Expect(target.Do(context.Background(), "LRANGE", "list", 0, -1)).To(Equal([]interface{}{"a", "b", "c"}))
+		Expect(target.Do(context.Background(), "SCARD", "set")).To(Equal(int64(2)))
+		Expect(target.Do(context.Background(), "ZSCORE", "zset", "b")).To(Equal("2.5"))
+	}
+
+	for _, mode := range []BackfillMode{BackfillAuto, BackfillTyped} {
+		mode := mode
+		It("copies all types of keys in "+string(mode)+" mode", func() {
+			backfiller, err := NewBackfiller(context.Background(), backfillConfig(mode))
+			Expect(err).NotTo(HaveOccurred())
+			defer backfiller.ShutDown(context.Background())
+
+			Expect(backfiller.Run(context.Background())).To(Succeed())
+			Expect(backfiller.Progress().Copied).To(Equal(int64(5)))
+			validate()
+		})
+	}
+
+	It("skips the keys existing in the target", func() {
+		_, err := target.Do(context.Background(), "SET", "string", "newer")
+		Expect(err).NotTo(HaveOccurred())
+
+		backfiller, err := NewBackfiller(context.Background(), backfillConfig(BackfillAuto))
+		Expect(err).NotTo(HaveOccurred())
+		defer backfiller.ShutDown(context.Background())
+
+		Expect(backfiller.Run(context.Background())).To(Succeed())
+		Expect(backfiller.Progress().Skipped).To(Equal(int64(1)))
+		Expect(target.Do(context.Background(), "GET", "string")).To(Equal("newer"))
+	})
+
+	It("doesn't merge the copy with the keys existing in the target in typed mode", func() {
+		_, err := target.Pipeline(context.Background(), [][]interface{}{
+			{"SET", "string", "newer"},
+			{"RPUSH", "list", "newer"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		backfiller, err := NewBackfiller(context.Background(), backfillConfig(BackfillTyped))
+		Expect(err).NotTo(HaveOccurred())
+		defer backfiller.ShutDown(context.Background())
+
+		Expect(backfiller.Run(context.Background())).To(Succeed())
+		Expect(backfiller.Progress().Skipped).To(Equal(int64(2)))
+		Expect(target.Do(context.Background(), "GET", "string")).To(Equal("newer"))
+		Expect(target.Do(context.Background(), "LRANGE", "list", 0, -1)).To(Equal([]interface{}{"newer"}))
+		Expect(target.Do(context.Background(), "EXISTS", "{list}"+backfillTempKeySuffix)).To(Equal(int64(0)))
+	})
+
+	It("writes the keys rewritten by the rules of the target", func() {
+		config := backfillConfig(BackfillTyped)
+		config.Target.KeyRewriteRules = []*KeyRewriteRule{{Action: RewriteAddPrefix, Prefix: "new:"}}
+		backfiller, err := NewBackfiller(context.Background(), config)
+		Expect(err).NotTo(HaveOccurred())
+		defer backfiller.ShutDown(context.Background())
+
+		Expect(backfiller.Run(context.Background())).To(Succeed())
+		Expect(target.Do(context.Background(), "GET", "new:string")).To(Equal("value"))
+		Expect(target.Do(context.Background(), "HGET", "new:hash", "f2")).To(Equal("v2"))
+		Expect(target.Do(context.Background(), "EXISTS", "string")).To(Equal(int64(0)))
+	})
+
+	It("resumes from the cursor file", func() {
+		config := backfillConfig(BackfillAuto)
+		config.CursorFile = filepath.Join(os.TempDir(), "backfill_cursor_"+time.Now().Format("150405.000"))
+		defer os.Remove(config.CursorFile)
+
+		backfiller, err := NewBackfiller(context.Background(), config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(backfiller.Run(context.Background())).To(Succeed())
+		backfiller.ShutDown(context.Background())
+
+		// all the nodes are done, nothing is scanned again
+		backfiller, err = NewBackfiller(context.Background(), config)
+		Expect(err).NotTo(HaveOccurred())
+		defer backfiller.ShutDown(context.Background())
+		Expect(backfiller.Run(context.Background())).To(Succeed())
+		Expect(backfiller.Progress().Scanned).To(Equal(int64(0)))
+	})
+})
+
+var _ = Describe("backfillTempKey", func() {
+	It("keeps the temp key in the slot of the key", func() {
+		for key, tempKey := range map[string]string{
+			"{user}:1": "{user}:1" + backfillTempKeySuffix,
+			"user:1":   "{user:1}" + backfillTempKeySuffix,
+			"user{:1":  "{user{:1}" + backfillTempKeySuffix,
+		} {
+			actual, ok := backfillTempKey(key)
+			Expect(ok).To(BeTrue())
+			Expect(actual).To(Equal(tempKey))
+			Expect(keyHash(actual)).To(Equal(keyHash(key)))
+		}
+
+		_, ok := backfillTempKey("user{}:1")
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("appendChunks", func() {
+	It("splits the values into chunks", func() {
+		values := make([]interface{}, backfillChunkSize+2)
+		argsList := appendChunks(nil, "SADD", "key", values)
+		Expect(argsList).To(HaveLen(2))
+		Expect(argsList[0]).To(HaveLen(backfillChunkSize + 2))
+		Expect(argsList[1]).To(HaveLen(4))
+	})
+})
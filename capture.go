@@ -0,0 +1,405 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// captureRecord is a cmd captured from the connector, the short field names keep the capture file compact
+type captureRecord struct {
+	// Time is the unix time in nanoseconds when the cmd was sent
+	Time int64 `json:"t"`
+	// Elapsed is the latency of the cmd on the main client in microseconds
+	Elapsed     int64      `json:"d"`
+	Function    string     `json:"f"`
+	Cmds        [][][]byte `json:"c,omitempty"`
+	Script      string     `json:"s,omitempty"`
+	KeyCount    int        `json:"k,omitempty"`
+	KeysAndArgs [][]byte   `json:"a,omitempty"`
+}
+
+func newCaptureRecord(req *loadTestRequest, start time.Time) *captureRecord {
+	spooled := newSpoolRecord("", req)
+	return &captureRecord{
+		Time:        start.UnixNano(),
+		Elapsed:     time.Since(start).Microseconds(),
+		Function:    strings.TrimPrefix(req.function, tagFunctionPrefix),
+		Cmds:        spooled.Cmds,
+		Script:      spooled.Script,
+		KeyCount:    spooled.KeyCount,
+		KeysAndArgs: spooled.KeysAndArgs,
+	}
+}
+
+func (r *captureRecord) request() *loadTestRequest {
+	spooled := &spoolRecord{
+		Function:    tagFunctionPrefix + r.Function,
+		Cmds:        r.Cmds,
+		Script:      r.Script,
+		KeyCount:    r.KeyCount,
+		KeysAndArgs: r.KeysAndArgs,
+	}
+	return spooled.request()
+}
+
+// capturer keeps the records waiting to be written to the capture file
+type capturer struct {
+	file         *captureFile
+	records      chan *captureRecord
+	redactKeys   bool
+	redactValues bool
+	// done is closed when the records left are written and the file is closed
+	done chan struct{}
+}
+
+func newCapturer(config *ConnectorConfig) (*capturer, error) {
+	file, err := newCaptureFile(config.CaptureFile, int64(config.CaptureMaxSizeInMB)<<20, config.CaptureMaxFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	return &capturer{
+		file:         file,
+		records:      make(chan *captureRecord, captureBufferSize),
+		redactKeys:   config.CaptureRedactKeys,
+		redactValues: config.CaptureRedactValues,
+		done:         make(chan struct{}),
+	}, nil
+}
+
+// captureFile is the capture file with one JSON record per line, it is rotated to path.1, path.2... when it is full
+type captureFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	file   *os.File
+	writer *bufio.Writer
+	size   int64
+}
+
+func newCaptureFile(path string, maxSize int64, maxFiles int) (*captureFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	f := &captureFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *captureFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	f.file, f.writer, f.size = file, bufio.NewWriter(file), info.Size()
+	return nil
+}
+
+func (f *captureFile) write(record *captureRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if f.size > 0 && f.size+int64(len(data)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := f.writer.Write(data)
+	f.size += int64(n)
+	return err
+}
+
+// rotate renames the file to path.1 after moving path.N to path.N+1, the files over maxFiles are removed
+func (f *captureFile) rotate() error {
+	if err := f.close(); err != nil {
+		return err
+	}
+
+	if err := os.Remove(rotatedCaptureFile(f.path, f.maxFiles)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := f.maxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(rotatedCaptureFile(f.path, i), rotatedCaptureFile(f.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(f.path, rotatedCaptureFile(f.path, 1)); err != nil {
+		return err
+	}
+
+	return f.open()
+}
+
+func (f *captureFile) flush() error {
+	return f.writer.Flush()
+}
+
+func (f *captureFile) close() error {
+	if err := f.writer.Flush(); err != nil {
+		_ = f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+func rotatedCaptureFile(path string, i int) string {
+	return path + "." + strconv.Itoa(i)
+}
+
+// captureFiles returns the capture file and its rotated files existing, from the oldest to the newest
+func captureFiles(path string) []string {
+	var files []string
+	for i := 1; ; i++ {
+		if _, err := os.Stat(rotatedCaptureFile(path, i)); err != nil {
+			break
+		}
+		files = append([]string{rotatedCaptureFile(path, i)}, files...)
+	}
+	return append(files, path)
+}
+
+// capture sends the request to the capture file, it is dropped if the capture file can't catch up
+func (c *connectorImpl) capture(req *loadTestRequest, start time.Time) {
+	if c.capturer.redactKeys || c.capturer.redactValues {
+		req = c.redact(req)
+	}
+
+	select {
+	case c.capturer.records <- newCaptureRecord(req, start):
+	default:
+		c.stats.Count1(pkgName, metricDropped, c.mainClient().getTags(tagFunctionCapture))
+	}
+}
+
+// writeCapture writes the captured records to the capture file until the ctx is done
+func (c *connectorImpl) writeCapture(ctx context.Context) {
+	defer close(c.capturer.done)
+
+	ticker := time.NewTicker(captureFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case record := <-c.capturer.records:
+			c.writeCaptureRecord(record)
+		case <-ticker.C:
+			if err := c.capturer.file.flush(); err != nil {
+				c.logger.Warn(pkgName, "failed to flush capture file, Error: %s", err)
+			}
+		case <-ctx.Done():
+			for {
+				select {
+				case record := <-c.capturer.records:
+					c.writeCaptureRecord(record)
+				default:
+					if err := c.capturer.file.close(); err != nil {
+						c.logger.Warn(pkgName, "failed to close capture file, Error: %s", err)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *connectorImpl) writeCaptureRecord(record *captureRecord) {
+	if err := c.capturer.file.write(record); err != nil {
+		c.stats.Count1(pkgName, metricError, c.mainClient().getTags(tagFunctionCapture))
+		c.logger.Error(pkgName, "failed to write capture file, Error: %s", err)
+	}
+}
+
+// redact returns a copy of the request with the keys and the values redacted by the capture options
+func (c *connectorImpl) redact(req *loadTestRequest) *loadTestRequest {
+	redacted := *req
+	if req.cmds != nil {
+		redacted.cmds = make([][]interface{}, len(req.cmds))
+		for i, cmd := range req.cmds {
+			isKey := make([]bool, len(cmd))
+			for _, pos := range c.mainClient().keyPositions(cmd) {
+				isKey[pos] = true
+			}
+			keep := keptArgs(cmd)
+
+			newCmd := make([]interface{}, len(cmd))
+			for j, arg := range cmd {
+				if j == 0 {
+					newCmd[j] = arg
+					continue
+				}
+				newCmd[j] = c.capturer.redactArg(arg, isKey[j], keep[j])
+			}
+			redacted.cmds[i] = newCmd
+		}
+	}
+
+	if req.script != nil {
+		keyCount := req.script.KeyCount()
+		redacted.keysAndArgs = make([]interface{}, len(req.keysAndArgs))
+		for i, arg := range req.keysAndArgs {
+			redacted.keysAndArgs[i] = c.capturer.redactArg(arg, i < keyCount, false)
+		}
+	}
+
+	return &redacted
+}
+
+// redactArg redacts the key or the value by the capture options, the kept values are the options and their TTL or count
+func (c *capturer) redactArg(arg interface{}, isKey bool, keep bool) interface{} {
+	if isKey {
+		if c.redactKeys {
+			return redactKey(argToString(arg))
+		}
+		return arg
+	}
+
+	if c.redactValues && !keep {
+		return redactValue(argToString(arg))
+	}
+	return arg
+}
+
+// redactKey replaces the key by its hash, the hash tag is replaced by the hash of the tag so the keys stay in the same slot
+func redactKey(key string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	if _, ok := hashTag(key); ok {
+		return fmt.Sprintf("{%x}%x", keyHash(key), h.Sum64())
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// redactValue replaces the value by x of the same length
+func redactValue(value string) string {
+	return strings.Repeat("x", len(value))
+}
+
+// captureArgSpec tells the args of a cmd kept in redacting the values, the positions count the cmd name as 0
+type captureArgSpec struct {
+	// numbers are the positions of the TTL or count args
+	numbers []int
+	// optionsFrom is the position from which the options like EX are accepted, the cmd has no option if it is 0
+	optionsFrom int
+}
+
+var captureArgSpecs = map[string]captureArgSpec{
+	"set":           {optionsFrom: 3},
+	"setex":         {numbers: []int{2}},
+	"psetex":        {numbers: []int{2}},
+	"getex":         {optionsFrom: 2},
+	"expire":        {numbers: []int{2}, optionsFrom: 3},
+	"pexpire":       {numbers: []int{2}, optionsFrom: 3},
+	"expireat":      {numbers: []int{2}, optionsFrom: 3},
+	"pexpireat":     {numbers: []int{2}, optionsFrom: 3},
+	"restore":       {numbers: []int{2}, optionsFrom: 4},
+	"lpop":          {numbers: []int{2}},
+	"rpop":          {numbers: []int{2}},
+	"spop":          {numbers: []int{2}},
+	"srandmember":   {numbers: []int{2}},
+	"hrandfield":    {numbers: []int{2}, optionsFrom: 3},
+	"zrandmember":   {numbers: []int{2}, optionsFrom: 3},
+	"lrange":        {numbers: []int{2, 3}},
+	"ltrim":         {numbers: []int{2, 3}},
+	"zadd":          {optionsFrom: 2},
+	"zrange":        {optionsFrom: 4},
+	"zrangebyscore": {optionsFrom: 4},
+	"zrangebylex":   {optionsFrom: 4},
+	"scan":          {numbers: []int{1}, optionsFrom: 2},
+	"hscan":         {numbers: []int{2}, optionsFrom: 3},
+	"sscan":         {numbers: []int{2}, optionsFrom: 3},
+	"zscan":         {numbers: []int{2}, optionsFrom: 3},
+}
+
+// captureOptions are the options kept in redacting the values, with the number of the TTL or count args following them
+var captureOptions = map[string]int{
+	"EX": 1, "PX": 1, "EXAT": 1, "PXAT": 1, "KEEPTTL": 0, "PERSIST": 0,
+	"NX": 0, "XX": 0, "GT": 0, "LT": 0, "GET": 0, "CH": 0, "INCR": 0,
+	"WITHSCORES": 0, "WITHVALUES": 0, "BYSCORE": 0, "BYLEX": 0, "REV": 0, "LIMIT": 2,
+	"COUNT": 1, "MATCH": 0, "TYPE": 0, "REPLACE": 0, "ABSTTL": 0, "IDLETIME": 1, "FREQ": 1,
+}
+
+// keptArgs returns whether each arg of the cmd is an option or a TTL or count arg of a known cmd, which is kept in redacting
+// the values so the replayed cmd stays valid. The numbers at the other positions are redacted, as they could be a card
+// number or a balance.
+func keptArgs(cmd []interface{}) []bool {
+	keep := make([]bool, len(cmd))
+	spec, ok := captureArgSpecs[strings.ToLower(cmdNameOf(cmd))]
+	if !ok {
+		return keep
+	}
+
+	for _, pos := range spec.numbers {
+		if pos < len(cmd) {
+			keep[pos] = isNumber(argToString(cmd[pos]))
+		}
+	}
+	if spec.optionsFrom == 0 {
+		return keep
+	}
+	for i := spec.optionsFrom; i < len(cmd); i++ {
+		args, ok := captureOptions[strings.ToUpper(argToString(cmd[i]))]
+		if !ok {
+			continue
+		}
+		keep[i] = true
+		for ; args > 0 && i+1 < len(cmd) && isNumber(argToString(cmd[i+1])); args-- {
+			i++
+			keep[i] = true
+		}
+	}
+	return keep
+}
+
+func isNumber(value string) bool {
+	_, err := strconv.ParseFloat(value, 64)
+	return err == nil
+}
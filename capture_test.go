@@ -0,0 +1,202 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	goredis "github.com/grab/redis/v8"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/grab/grab-redis/redisapi"
+)
+
+var _ = Describe("Test Capture", func() {
+	var dir string
+	var c *connectorImpl
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "capture")
+		Expect(err).NotTo(HaveOccurred())
+
+		c = &connectorImpl{
+			stats:  NewNoopStatsClient(),
+			logger: NewNoopLogger(),
+		}
+		c.routing.Store(&routing{
+			client: &clientImpl{
+				cmdCache: map[string]*goredis.CommandInfo{
+					"set": {Name: "set", FirstKeyPos: 1, LastKeyPos: 1, StepCount: 1},
+				},
+			},
+		})
+		c.capturer, err = newCapturer(&ConnectorConfig{
+			CaptureFile:        filepath.Join(dir, "capture.log"),
+			CaptureMaxSizeInMB: 1,
+			CaptureMaxFiles:    2,
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	It("writes the captured cmds and closes the file when the ctx is done", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		go c.writeCapture(ctx)
+
+		c.capture(newDoRequest("SET", []interface{}{"k", "v"}), time.Now())
+		c.capture(newRunRequest(redisapi.NewScript(1, "return 1"), []interface{}{"k", 1}), time.Now())
+		cancel()
+		<-c.capturer.done
+
+		data, err := os.ReadFile(filepath.Join(dir, "capture.log"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(strings.Count(string(data), "\n")).To(Equal(2))
+		Expect(string(data)).To(ContainSubstring(`"f":"do"`))
+		Expect(string(data)).To(ContainSubstring(`"f":"run"`))
+	})
+
+	It("rotates the file and removes the oldest one", func() {
+		c.capturer.file.maxSize = 100
+		for i := 0; i < 10; i++ {
+			Expect(c.capturer.file.write(newCaptureRecord(newDoRequest("SET", []interface{}{"k", i}), time.Now()))).To(Succeed())
+		}
+		Expect(c.capturer.file.close()).To(Succeed())
+
+		path := filepath.Join(dir, "capture.log")
+		Expect(captureFiles(path)).To(Equal([]string{path + ".2", path + ".1", path}))
+		_, err := os.Stat(path + ".3")
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("redacts the keys and the values", func() {
+		c.capturer.redactKeys = true
+		c.capturer.redactValues = true
+
+		redacted := c.redact(newDoRequest("SET", []interface{}{"{user:1}:name", "alice", "EX", 10}))
+		cmd := redacted.cmds[0]
+		Expect(cmd[0]).To(Equal("SET"))
+		Expect(cmd[1]).NotTo(ContainSubstring("user"))
+		Expect(cmd[1]).To(Equal(redactKey("{user:1}:name")))
+		Expect(cmd[2:]).To(Equal([]interface{}{"xxxxx", "EX", 10}))
+
+		// the keys with the same hash tag stay in the same slot
+		tag, ok := hashTag(redactKey("{user:1}:age"))
+		Expect(ok).To(BeTrue())
+		redactedTag, _ := hashTag(cmd[1].(string))
+		Expect(redactedTag).To(Equal(tag))
+
+		redacted = c.redact(newRunRequest(redisapi.NewScript(1, "return 1"), []interface{}{"k", "secret"}))
+		Expect(redacted.keysAndArgs).To(Equal([]interface{}{redactKey("k"), "xxxxxx"}))
+	})
+
+	It("only keeps the options and their TTL or count", func() {
+		c.capturer.redactValues = true
+
+		redact := func(cmd ...interface{}) []interface{} {
+			return c.redact(newDoRequest(cmd[0].(string), cmd[1:])).cmds[0]
+		}
+		// the numbers and the upper case tokens out of the option positions are values
+		Expect(redact("SET", "k", "4111111111111111", "px", 100, "NX")).To(Equal([]interface{}{"SET", "k", "xxxxxxxxxxxxxxxx", "px", 100, "NX"}))
+		Expect(redact("SET", "k", "ACTIVE")).To(Equal([]interface{}{"SET", "k", "xxxxxx"}))
+		// the keys of the cmds missing in the command cache are taken as values
+		Expect(redact("HSET", "k", "balance", "1024.50")).To(Equal([]interface{}{"HSET", "x", "xxxxxxx", "xxxxxxx"}))
+		Expect(redact("SETEX", "k", 60, "91234567")).To(Equal([]interface{}{"SETEX", "x", 60, "xxxxxxxx"}))
+		Expect(redact("ZADD", "k", "XX", "CH", 1.5, "m")).To(Equal([]interface{}{"ZADD", "x", "XX", "CH", "xxx", "x"}))
+		Expect(redact("ZRANGE", "k", 0, 10, "BYSCORE", "LIMIT", 0, 5)).To(Equal([]interface{}{"ZRANGE", "x", "x", "xx", "BYSCORE", "LIMIT", 0, 5}))
+	})
+})
+
+var _ = Describe("Test Replay", func() {
+	var r *Replayer
+	var mu sync.Mutex
+	var sent []time.Time
+
+	BeforeEach(func() {
+		sent = nil
+		r = newReplayer(&ReplayConfig{Speed: 2, Concurrency: 10})
+		r.execute = func(ctx context.Context, req *loadTestRequest) error {
+			mu.Lock()
+			defer mu.Unlock()
+			sent = append(sent, time.Now())
+			if req.cmds[0][0] != "SET" {
+				return errors.New("failed")
+			}
+			return nil
+		}
+	})
+
+	capture := func(gaps ...time.Duration) string {
+		start := time.Now()
+		var lines []string
+		for i, gap := range gaps {
+			start = start.Add(gap)
+			cmdName := "SET"
+			if i == len(gaps)-1 {
+				cmdName = "GET"
+			}
+			record := newCaptureRecord(newDoRequest(cmdName, []interface{}{"k"}), start)
+			data, err := json.Marshal(record)
+			Expect(err).NotTo(HaveOccurred())
+			lines = append(lines, string(data))
+		}
+		return strings.Join(lines, "\n") + "\nbroken\n"
+	}
+
+	It("plays the capture at the scaled speed", func() {
+		start := time.Now()
+		Expect(r.play(context.Background(), strings.NewReader(capture(0, 100*time.Millisecond, 100*time.Millisecond)))).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically("~", 100*time.Millisecond, 40*time.Millisecond))
+		Expect(r.Progress()).To(Equal(ReplayProgress{Sent: 3, Failed: 1, Skipped: 1}))
+	})
+
+	It("plays the capture as fast as possible when it is unpaced", func() {
+		r.config.Unpaced = true
+		start := time.Now()
+		Expect(r.play(context.Background(), strings.NewReader(capture(0, time.Second, time.Second)))).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically("<", 100*time.Millisecond))
+		Expect(sent).To(HaveLen(3))
+	})
+
+	It("stops when the ctx is done", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		Expect(r.play(ctx, strings.NewReader(capture(0, time.Second)))).To(Equal(context.DeadlineExceeded))
+		Expect(r.Progress().Sent).To(Equal(int64(1)))
+	})
+})
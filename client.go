@@ -29,7 +29,9 @@ package redis
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/grab/grab-redis/circuitbreaker"
@@ -48,6 +50,8 @@ type clientImpl struct {
 	logger    Logger
 	cbOptions []circuitbreaker.Option
 	cmdCache  map[string]*goredis.CommandInfo
+	// opsLimiter caps the async mirror requests when the client is a load test client
+	opsLimiter *tokenBucket
 }
 
 func NewClient(ctx context.Context, config *ClientConfig, options ...ClientOption) (redisapi.Client, error) {
@@ -78,6 +82,7 @@ func newClient(ctx context.Context, config *ClientConfig, options ...ClientOptio
 	}
 
 	c.config = config
+	c.opsLimiter = newTokenBucket(config.MaxOpsPerSecond, config.MaxOpsBurst)
 	c.cmdCache, _ = c.wrappedClient.Command(ctx).Result()
 
 	select {
@@ -92,7 +97,11 @@ func newClient(ctx context.Context, config *ClientConfig, options ...ClientOptio
 }
 
 func (c *clientImpl) reload(config *ClientConfig) error {
-	return c.wrappedClient.reload(config, c.cbOptions)
+	if err := c.wrappedClient.reload(config, c.cbOptions); err != nil {
+		return err
+	}
+	c.opsLimiter.update(config.MaxOpsPerSecond, config.MaxOpsBurst)
+	return nil
 }
 
 func (c *clientImpl) ifCommandReadonly(name string) (bool, error) {
@@ -102,6 +111,71 @@ func (c *clientImpl) ifCommandReadonly(name string) (bool, error) {
 	return c.cmdCache[name].ReadOnly, nil
 }
 
+// writeCmds returns the cmds which are not read-only, the cmds missing in the command cache are taken as writes
+func (c *clientImpl) writeCmds(argsList [][]interface{}) [][]interface{} {
+	writes := make([][]interface{}, 0, len(argsList))
+	for _, args := range argsList {
+		if readonly, _ := c.ifCommandReadonly(strings.ToLower(cmdNameOf(args))); !readonly {
+			writes = append(writes, args)
+		}
+	}
+	return writes
+}
+
+func (c *clientImpl) ifCommandHasFlag(name string, flag string) bool {
+	if len(c.cmdCache) == 0 || c.cmdCache[name] == nil {
+		return false
+	}
+	for _, f := range c.cmdCache[name].Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// firstKey returns the first key of the cmd by the key position in the command cache
+func (c *clientImpl) firstKey(cmd []interface{}) (string, bool) {
+	if len(cmd) == 0 {
+		return "", false
+	}
+	name, _ := cmd[0].(string)
+	info := c.cmdCache[strings.ToLower(name)]
+	if info == nil || info.FirstKeyPos <= 0 || int(info.FirstKeyPos) >= len(cmd) {
+		return "", false
+	}
+	return argToString(cmd[info.FirstKeyPos]), true
+}
+
+// keyPositions returns the positions of all the keys in the cmd by the key specs in the command cache,
+// the cmds with movable keys, e.g. EVAL, only return the keys at the fixed positions.
+func (c *clientImpl) keyPositions(cmd []interface{}) []int {
+	if len(cmd) == 0 {
+		return nil
+	}
+	name, _ := cmd[0].(string)
+	info := c.cmdCache[strings.ToLower(name)]
+	if info == nil || info.FirstKeyPos <= 0 {
+		return nil
+	}
+
+	last := int(info.LastKeyPos)
+	if last < 0 {
+		// negative position counts from the end, e.g. -1 is the last arg
+		last += len(cmd)
+	}
+	step := int(info.StepCount)
+	if step <= 0 {
+		step = 1
+	}
+
+	var positions []int
+	for i := int(info.FirstKeyPos); i <= last && i < len(cmd); i += step {
+		positions = append(positions, i)
+	}
+	return positions
+}
+
 // Do sends a redis command to a read and write enabled node
 func (c *clientImpl) Do(ctx context.Context, cmdName string, args ...interface{}) (interface{}, error) {
 	defer c.stats.Duration(pkgName, metricElapsed, time.Now(), c.getTags(tagFunctionDo, tagCmdPrefix+cmdName)...)
@@ -174,11 +248,16 @@ func (c *clientImpl) Subscribe(ctx context.Context, chanBufferSize int, channels
 
 	ch := sub.Channel()
 	resultChan := make(chan interface{}, chanBufferSize)
+	done := make(chan struct{})
 	go func() {
 		for msg := range ch {
-			resultChan <- &redisapi.SubscribeMessage{
+			select {
+			case resultChan <- &redisapi.SubscribeMessage{
 				Channel: msg.Channel,
 				Data:    []byte(msg.Payload),
+			}:
+			case <-done:
+				// nobody reads the messages after unsubscribing
 			}
 		}
 
@@ -186,14 +265,45 @@ func (c *clientImpl) Subscribe(ctx context.Context, chanBufferSize int, channels
 		close(resultChan)
 	}()
 
+	var once sync.Once
 	return &redisapi.SubscribeResponse{
 		ResultChan: resultChan,
 		Unsubscribe: func() {
-			_ = sub.Unsubscribe(context.Background(), channels...)
+			once.Do(func() {
+				close(done)
+				_ = sub.Unsubscribe(context.Background(), channels...)
+				// closing the PubSub releases its connection and closes ch, so the goroutine above exits
+				_ = sub.Close()
+			})
 		},
 	}, nil
 }
 
+// warmUp opens MinIdleConns connections, or one if it is not set, to every master node before the client serves the traffic
+func (c *clientImpl) warmUp(ctx context.Context) error {
+	conns := c.config.MinIdleConns
+	if conns < 1 {
+		conns = 1
+	}
+
+	return c.wrappedClient.forEachMaster(ctx, func(ctx context.Context, node *goredis.Client) error {
+		errs := make(chan error, conns)
+		for i := 0; i < conns; i++ {
+			go func() {
+				errs <- node.Do(ctx, "PING").Err()
+			}()
+		}
+
+		var err error
+		for i := 0; i < conns; i++ {
+			if pingErr := <-errs; pingErr != nil {
+				err = pingErr
+			}
+		}
+		return err
+	})
+}
+
 // ShutDown will stop the status reporting, close the pools and other clean up.
 func (c *clientImpl) ShutDown(ctx context.Context) {
 	if _, ok := ctx.Deadline(); !ok {
@@ -268,3 +378,14 @@ func (c *clientImpl) getTags(tags ...string) []string {
 	statsTags = append(statsTags, tags...)
 	return statsTags
 }
+
+func argToString(arg interface{}) string {
+	switch v := arg.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -27,6 +27,8 @@
 package redis
 
 import (
+	"context"
+
 	cb "github.com/grab/grab-redis/circuitbreaker"
 	goredis "github.com/grab/redis/v8"
 )
@@ -132,6 +134,24 @@ func (c *clientWrapperImpl) reload(config *ClientConfig, cbOptions []cb.Option)
 	c.config.HystrixEnabled = config.HystrixEnabled
 
 	c.config.IgnoreReadOnly = config.IgnoreReadOnly
+	c.config.SyncWrite = config.SyncWrite
+	c.config.SyncWritePolicy = config.SyncWritePolicy
+	c.config.SyncWriteMaxRetries = config.SyncWriteMaxRetries
+	c.config.SyncWriteRetryBackoffInMs = config.SyncWriteRetryBackoffInMs
+	c.config.SampleRate = config.SampleRate
+	c.config.SampleMode = config.SampleMode
+	c.config.MirrorMaxRetries = config.MirrorMaxRetries
+	c.config.MirrorRetryBackoffInMs = config.MirrorRetryBackoffInMs
+	c.config.MirrorRetryMaxBackoffInMs = config.MirrorRetryMaxBackoffInMs
+	c.config.MaxOpsPerSecond = config.MaxOpsPerSecond
+	c.config.MaxOpsBurst = config.MaxOpsBurst
+	c.config.AmplificationFactor = config.AmplificationFactor
+	c.config.AmplificationKeySuffix = config.AmplificationKeySuffix
+	c.config.KeyRewriteRules = config.KeyRewriteRules
 
 	return nil
 }
+
+func (c *clientWrapperImpl) forEachMaster(ctx context.Context, fn func(ctx context.Context, client *goredis.Client) error) error {
+	return fn(ctx, c.Client)
+}
@@ -145,6 +145,20 @@ func (c *clusterWrapperImpl) reload(config *ClientConfig, cbOptions []circuitbre
 	}
 
 	c.config.IgnoreReadOnly = config.IgnoreReadOnly
+	c.config.SyncWrite = config.SyncWrite
+	c.config.SyncWritePolicy = config.SyncWritePolicy
+	c.config.SyncWriteMaxRetries = config.SyncWriteMaxRetries
+	c.config.SyncWriteRetryBackoffInMs = config.SyncWriteRetryBackoffInMs
+	c.config.SampleRate = config.SampleRate
+	c.config.SampleMode = config.SampleMode
+	c.config.MirrorMaxRetries = config.MirrorMaxRetries
+	c.config.MirrorRetryBackoffInMs = config.MirrorRetryBackoffInMs
+	c.config.MirrorRetryMaxBackoffInMs = config.MirrorRetryMaxBackoffInMs
+	c.config.MaxOpsPerSecond = config.MaxOpsPerSecond
+	c.config.MaxOpsBurst = config.MaxOpsBurst
+	c.config.AmplificationFactor = config.AmplificationFactor
+	c.config.AmplificationKeySuffix = config.AmplificationKeySuffix
+	c.config.KeyRewriteRules = config.KeyRewriteRules
 
 	if c.config.ReadMode != config.ReadMode {
 		c.config.ReadMode = config.ReadMode
@@ -166,3 +180,7 @@ func (c *clusterWrapperImpl) reload(config *ClientConfig, cbOptions []circuitbre
 
 	return nil
 }
+
+func (c *clusterWrapperImpl) forEachMaster(ctx context.Context, fn func(ctx context.Context, client *goredis.Client) error) error {
+	return c.ForEachMaster(ctx, fn)
+}
@@ -30,6 +30,8 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"math"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
@@ -44,6 +46,11 @@ type ConnectorConfig struct {
 	LoadTests []*ClientConfig `json:"loadTests"`
 
 	HotReload bool `json:"hotReload"`
+	// MigrationPhase specifies the phase of migrating from the main client to the first load test client,
+	// could be PhaseOff, PhaseDualWrite, PhaseShadowRead, PhaseReadFromNew, PhaseCutover, PhaseRollback or PhaseReadThrough.
+	// When it is set, IgnoreReadOnly and ShadowRead are decided by the phase, and only the legal phase changes are allowed in reloading.
+	// Leave it empty to route the traffic by IgnoreReadOnly and ShadowRead.
+	MigrationPhase MigrationPhase `json:"migrationPhase"`
 	// ProcessAllLoadTestPackets this option is for not allowing losing packets when the channel is full when you dual write in new clutser.
 	// It will increase the latency of requests.
 	ProcessAllLoadTestPackets bool `json:"processAllLoadTestPackets"`
@@ -54,6 +61,105 @@ type ConnectorConfig struct {
 	SchedulerChannelSize int `json:"schedulerChannelSize"`
 	// SchedulerWorkerIdleTimeout specifies the max idle time for a worker, if the worker is idle for this time, it will be terminated.
 	SchedulerWorkerIdleTimeoutInMs int `json:"schedulerWorkerIdleTimeout"`
+	// SchedulerOrderedByKey keeps the order of the load test requests of the same key, the requests are sent to
+	// SchedulerWorkerNumber lanes by the hash of the first key, and each lane is executed by one worker.
+	// Only the first key of a pipeline is used, so the other keys of the pipeline are not ordered with the requests of
+	// those keys. The writes dropped by a full lane are written to the DeadLetterSink instead of the spool, as a replayed
+	// write is not ordered with the live requests. It is not hot-reloadable.
+	SchedulerOrderedByKey bool `json:"schedulerOrderedByKey"`
+	// SchedulerLatencyPercentile is the percentile (0 to 1) of the execution latency used to decide the number of workers,
+	// e.g. 0.95 for p95, so a few slow requests don't skew the scaling. The average is used if it is 0.
+	SchedulerLatencyPercentile float64 `json:"schedulerLatencyPercentile"`
+	// SchedulerMinWorkerNumber workers are kept even if they are idle, so a burst doesn't wait for the workers to be spawned.
+	SchedulerMinWorkerNumber int `json:"schedulerMinWorkerNumber"`
+	// SchedulerScaleCooldownInMs is the min time between a scaling and a scale down, to avoid the churn of the workers in bursts.
+	SchedulerScaleCooldownInMs int `json:"schedulerScaleCooldownInMs"`
+
+	// ShadowRead sends the read-only cmds of Do and DoReadOnly to the load test clients asynchronously and compares the replies with the main client's.
+	// The results are reported as match/mismatch metrics, it is used to check if the new cluster has converged before cutover.
+	ShadowRead bool `json:"shadowRead"`
+	// ShadowReadLogSampleRate specifies the ratio (0 to 1) of the mismatches being logged, 0 disables the logging.
+	// It defaults to 0.01 if unset.
+	ShadowReadLogSampleRate *float64 `json:"shadowReadLogSampleRate"`
+
+	// SpoolDir enables the overflow spool, the load test requests dropped by a full scheduler queue are appended to a file
+	// in this directory and replayed once the backlog drains. It is ignored if ProcessAllLoadTestPackets is enabled.
+	// The replayed writes may be older than the live writes of the same keys sent in the meantime, e.g. a spooled SET
+	// replayed after a live DEL resurrects the key, so they are also recorded to the dead letter sink if there is one.
+	// It is not hot-reloadable.
+	SpoolDir string `json:"spoolDir"`
+	// SpoolMaxSizeInMB is the max size of the spool file, the replayed requests are compacted out when it is full, and the
+	// requests are dropped if the pending ones still fill it.
+	SpoolMaxSizeInMB int `json:"spoolMaxSizeInMB"`
+
+	// DeadLetterFile is the file of the default dead letter sink, the async mirror writes still failing after the retries are
+	// appended to it. It is ignored if a sink is given by ConnectorDeadLetterSink. It is not hot-reloadable.
+	DeadLetterFile string `json:"deadLetterFile"`
+
+	// CaptureFile enables the capture mode, the cmds of Do, Pipeline and Run are appended to this file with their timing,
+	// so they can be played by a Replayer later. It is not hot-reloadable.
+	CaptureFile string `json:"captureFile"`
+	// CaptureMaxSizeInMB is the max size of the capture file, it is rotated to CaptureFile.1 when it is full.
+	CaptureMaxSizeInMB int `json:"captureMaxSizeInMB"`
+	// CaptureMaxFiles is the number of the rotated capture files kept, the oldest one is removed in rotation.
+	CaptureMaxFiles int `json:"captureMaxFiles"`
+	// CaptureRedactKeys replaces the keys by their hash, the keys with the same hash tag stay in the same slot.
+	CaptureRedactKeys bool `json:"captureRedactKeys"`
+	// CaptureRedactValues replaces the other args by x of the same length. Only the options like EX and the TTL or count
+	// args of the known cmds, e.g. the seconds of SETEX, are kept so the replayed cmds stay valid, the other numbers are redacted.
+	CaptureRedactValues bool `json:"captureRedactValues"`
+
+	// Fallback is the client serving the cmds when the main client fails with a circuit open or timeout error, e.g. a
+	// replica cluster, so a node failure degrades to the second cluster instead of returning the errors. The load test
+	// client of the same address is used if there is one, otherwise a new client is created. It is not hot-reloadable,
+	// and the load test client used as the fallback client can't be removed in reloading.
+	Fallback *ClientConfig `json:"fallback"`
+	// FallbackWrites sends the failed write cmds to the fallback client too, only the read-only cmds fall back if it is false.
+	// It shouldn't be enabled if the writes are mirrored to the fallback client, or they are applied twice.
+	FallbackWrites bool `json:"fallbackWrites"`
+
+	// MirrorRules decide which cmds are sent to the load test clients, the first matched rule is applied.
+	// If no rule is matched, the cmd is mirrored unless there is an allow rule for the load test client.
+	MirrorRules []*MirrorRule `json:"mirrorRules"`
+}
+
+// MirrorRule allows or denies mirroring the cmds by the cmd name and the key pattern.
+type MirrorRule struct {
+	// Action could be MirrorAllow or MirrorDeny.
+	Action MirrorAction `json:"action"`
+	// Commands are the cmd names matched by the rule, e.g. DEL, all the cmds are matched if it is empty.
+	// Run is matched as EVAL, Publish as PUBLISH and Subscribe as SUBSCRIBE.
+	Commands []string `json:"commands"`
+	// KeyPattern is the glob-style pattern of the first key, e.g. user:*, the cmds without key are not matched if it is set.
+	KeyPattern string `json:"keyPattern"`
+	// LoadTests are the names of the load test clients the rule applies to, it applies to all if it is empty.
+	// The name is the same as the host tag of the metrics, i.e. the address of a single host, or the sorted addresses joined by comma.
+	LoadTests []string `json:"loadTests"`
+
+	commands   map[string]bool
+	keyPattern *regexp.Regexp
+}
+
+func (r *MirrorRule) initAndValidate() error {
+	if !r.Action.IsValid() {
+		return fmt.Errorf("mirror rule action %s is not valid", r.Action)
+	}
+
+	r.commands = make(map[string]bool, len(r.Commands))
+	for _, cmd := range r.Commands {
+		r.commands[strings.ToLower(cmd)] = true
+	}
+
+	r.keyPattern = nil
+	if r.KeyPattern != "" && r.KeyPattern != ucmEmptyString {
+		pattern, err := globToRegexp(r.KeyPattern)
+		if err != nil {
+			return fmt.Errorf("mirror rule key pattern %s is not valid: %s", r.KeyPattern, err)
+		}
+		r.keyPattern = pattern
+	}
+
+	return nil
 }
 
 func (c *ConnectorConfig) initAndValidate() error {
@@ -71,6 +177,34 @@ func (c *ConnectorConfig) initAndValidate() error {
 		}
 	}
 
+	if c.MigrationPhase == ucmEmptyString {
+		c.MigrationPhase = ""
+	}
+
+	if c.MigrationPhase != "" && !c.MigrationPhase.IsValid() {
+		return fmt.Errorf("migration phase %s is not valid", c.MigrationPhase)
+	}
+
+	if c.MigrationPhase.In(PhaseDualWrite, PhaseShadowRead, PhaseReadFromNew, PhaseCutover, PhaseReadThrough) && len(c.LoadTests) == 0 {
+		return fmt.Errorf("migration phase %s requires at least one load test client", c.MigrationPhase)
+	}
+
+	// the keys are only rewritten in mirroring, the reads and writes routed to the new cluster keep the original keys
+	if c.MigrationPhase.In(PhaseReadFromNew, PhaseCutover, PhaseReadThrough) && len(c.LoadTests[0].KeyRewriteRules) > 0 {
+		return fmt.Errorf("migration phase %s is not allowed with the key rewrite rules of the first load test client", c.MigrationPhase)
+	}
+
+	// the reads routed to the new cluster miss the keys which are not mirrored to it
+	if c.MigrationPhase.In(PhaseReadFromNew, PhaseCutover, PhaseReadThrough) && !c.LoadTests[0].mirrorsAll(c.MirrorRules) {
+		return fmt.Errorf("migration phase %s requires all the traffic mirrored to the first load test client, without sampling, rate limit or mirror rules", c.MigrationPhase)
+	}
+
+	// the writes are still mirrored to the new cluster serving the reads, a write dropped by a full queue would be read as
+	// a miss or a stale value. The writes go to the new cluster directly in the later phases.
+	if c.MigrationPhase == PhaseReadFromNew && !c.LoadTests[0].SyncWrite && !c.ProcessAllLoadTestPackets {
+		return fmt.Errorf("migration phase %s requires SyncWrite of the first load test client or ProcessAllLoadTestPackets", c.MigrationPhase)
+	}
+
 	if c.SchedulerWorkerNumber == 0 {
 		c.SchedulerWorkerNumber = defaultMaxWorker
 	}
@@ -83,6 +217,270 @@ func (c *ConnectorConfig) initAndValidate() error {
 		c.SchedulerWorkerIdleTimeoutInMs = defaultWorkerIdleTimeout
 	}
 
+	if c.SchedulerScaleCooldownInMs == 0 {
+		c.SchedulerScaleCooldownInMs = defaultSchedulerScaleCooldownInMs
+	}
+
+	if c.SchedulerMinWorkerNumber < 0 || c.SchedulerMinWorkerNumber > c.SchedulerWorkerNumber {
+		return fmt.Errorf("scheduler min worker number %d is not valid", c.SchedulerMinWorkerNumber)
+	}
+
+	if c.SchedulerLatencyPercentile < 0 || c.SchedulerLatencyPercentile > 1 {
+		return fmt.Errorf("scheduler latency percentile %v is not valid", c.SchedulerLatencyPercentile)
+	}
+
+	if c.ShadowReadLogSampleRate == nil {
+		rate := defaultShadowReadLogSampleRate
+		c.ShadowReadLogSampleRate = &rate
+	}
+
+	if *c.ShadowReadLogSampleRate < 0 || *c.ShadowReadLogSampleRate > 1 {
+		return fmt.Errorf("shadow read log sample rate %v is not valid", *c.ShadowReadLogSampleRate)
+	}
+
+	if c.SpoolDir == ucmEmptyString {
+		c.SpoolDir = ""
+	}
+
+	if c.DeadLetterFile == ucmEmptyString {
+		c.DeadLetterFile = ""
+	}
+
+	if c.SpoolMaxSizeInMB == 0 {
+		c.SpoolMaxSizeInMB = defaultSpoolMaxSizeInMB
+	}
+
+	if c.SpoolMaxSizeInMB < 0 {
+		return fmt.Errorf("spool max size %d is not valid", c.SpoolMaxSizeInMB)
+	}
+
+	if c.CaptureFile == ucmEmptyString {
+		c.CaptureFile = ""
+	}
+
+	if c.CaptureMaxSizeInMB == 0 {
+		c.CaptureMaxSizeInMB = defaultCaptureMaxSizeInMB
+	}
+
+	if c.CaptureMaxSizeInMB < 0 {
+		return fmt.Errorf("capture max size %d is not valid", c.CaptureMaxSizeInMB)
+	}
+
+	if c.CaptureMaxFiles == 0 {
+		c.CaptureMaxFiles = defaultCaptureMaxFiles
+	}
+
+	if c.CaptureMaxFiles < 0 {
+		return fmt.Errorf("capture max files %d is not valid", c.CaptureMaxFiles)
+	}
+
+	for _, rule := range c.MirrorRules {
+		if err := rule.initAndValidate(); err != nil {
+			return err
+		}
+	}
+
+	if c.Fallback != nil {
+		c.Fallback.init()
+		if err := c.Fallback.validate(); err != nil {
+			return err
+		}
+		if isAddrsEquals(c.Main.Addrs, c.Fallback.Addrs) {
+			return fmt.Errorf("fallback client can't share the same address with the main client")
+		}
+		// the cmds falling back keep the original keys
+		if len(c.Fallback.KeyRewriteRules) > 0 {
+			return fmt.Errorf("fallback client is not allowed with key rewrite rules")
+		}
+		for _, config := range c.LoadTests {
+			if config.name() == c.Fallback.name() && len(config.KeyRewriteRules) > 0 {
+				return fmt.Errorf("load test client %s with key rewrite rules can't be the fallback client", config.name())
+			}
+		}
+	}
+
+	return nil
+}
+
+// BackfillConfig keeps the settings to copy the existing keys from the source to the target.
+// Dual write only covers the keys written after it is enabled, the backfill copies the keys written before.
+type BackfillConfig struct {
+	Source *ClientConfig `json:"source"`
+	Target *ClientConfig `json:"target"`
+
+	// Mode specifies how the keys are copied, could be BackfillAuto, BackfillDump or BackfillTyped.
+	Mode BackfillMode `json:"mode"`
+	// Match only copies the keys matching the glob-style pattern, all the keys are copied if it is empty.
+	Match string `json:"match"`
+	// ScanCount is the COUNT hint of each SCAN, it is also the number of keys being copied in one pipeline.
+	ScanCount int `json:"scanCount"`
+	// MaxKeysPerSecond limits the number of keys being copied per second among all the nodes.
+	MaxKeysPerSecond int `json:"maxKeysPerSecond"`
+	// Replace overwrites the keys existing in the target.
+	// By default the existing keys are skipped, as they are written by dual write and newer than the copy.
+	Replace bool `json:"replace"`
+	// CursorFile is the file to save the SCAN cursor of each node, the backfill resumes from the saved cursors when it is restarted.
+	CursorFile string `json:"cursorFile"`
+}
+
+func (c *BackfillConfig) init() {
+	if c.Source != nil {
+		c.Source.init()
+	}
+
+	if c.Target != nil {
+		c.Target.init()
+	}
+
+	if c.Mode == "" || c.Mode == ucmEmptyString {
+		c.Mode = defaultBackfillMode
+	}
+
+	if c.ScanCount == 0 {
+		c.ScanCount = defaultBackfillScanCount
+	}
+
+	if c.MaxKeysPerSecond == 0 {
+		c.MaxKeysPerSecond = defaultBackfillMaxKeysPerSecond
+	}
+}
+
+func (c *BackfillConfig) validate() error {
+	if c.Source == nil || c.Target == nil {
+		return fmt.Errorf("both source and target are required for backfill")
+	}
+
+	if err := c.Source.validate(); err != nil {
+		return err
+	}
+
+	if err := c.Target.validate(); err != nil {
+		return err
+	}
+
+	if isAddrsEquals(c.Source.Addrs, c.Target.Addrs) {
+		return fmt.Errorf("can't backfill to the same address as the source")
+	}
+
+	if !c.Mode.IsValid() {
+		return fmt.Errorf("backfill mode %s is not valid", c.Mode)
+	}
+
+	if c.ScanCount < 0 || c.MaxKeysPerSecond < 0 {
+		return fmt.Errorf("scan count and max keys per second of backfill can't be negative")
+	}
+
+	return nil
+}
+
+// VerifyConfig keeps the settings to compare the keys of the main client with the load test clients.
+type VerifyConfig struct {
+	Main      *ClientConfig   `json:"main"`
+	LoadTests []*ClientConfig `json:"loadTests"`
+
+	// SampleSize is the number of random keys being compared, all the keys are scanned and compared if it is 0.
+	SampleSize int `json:"sampleSize"`
+	// Match only compares the keys matching the glob-style pattern when scanning all the keys.
+	Match string `json:"match"`
+	// ScanCount is the COUNT hint of each SCAN when scanning all the keys.
+	ScanCount int `json:"scanCount"`
+	// MaxKeysPerSecond limits the number of keys being compared per second among all the nodes.
+	MaxKeysPerSecond int `json:"maxKeysPerSecond"`
+	// TTLToleranceInMs is the max difference between the TTLs of the same key to be considered as the same.
+	TTLToleranceInMs int `json:"ttlToleranceInMs"`
+	// MaxReportedMismatches limits the number of mismatched keys kept in the report, the counts are not limited.
+	MaxReportedMismatches int `json:"maxReportedMismatches"`
+}
+
+func (c *VerifyConfig) init() {
+	if c.Main != nil {
+		c.Main.init()
+	}
+
+	for _, config := range c.LoadTests {
+		config.init()
+	}
+
+	if c.ScanCount == 0 {
+		c.ScanCount = defaultBackfillScanCount
+	}
+
+	if c.MaxKeysPerSecond == 0 {
+		c.MaxKeysPerSecond = defaultBackfillMaxKeysPerSecond
+	}
+
+	if c.TTLToleranceInMs == 0 {
+		c.TTLToleranceInMs = defaultVerifyTTLToleranceInMs
+	}
+
+	if c.MaxReportedMismatches == 0 {
+		c.MaxReportedMismatches = defaultVerifyMaxReportedMismatches
+	}
+}
+
+func (c *VerifyConfig) validate() error {
+	if c.Main == nil || len(c.LoadTests) == 0 {
+		return fmt.Errorf("both main and load tests are required for verify")
+	}
+
+	if err := c.Main.validate(); err != nil {
+		return err
+	}
+
+	for _, config := range c.LoadTests {
+		if err := config.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.SampleSize < 0 || c.ScanCount < 0 || c.MaxKeysPerSecond < 0 || c.TTLToleranceInMs < 0 {
+		return fmt.Errorf("sample size, scan count, max keys per second and ttl tolerance of verify can't be negative")
+	}
+
+	return nil
+}
+
+// ReplayConfig keeps the settings to play a capture against a client.
+type ReplayConfig struct {
+	Target *ClientConfig `json:"target"`
+
+	// CaptureFile is the CaptureFile of the connector, the rotated files are played from the oldest before it.
+	CaptureFile string `json:"captureFile"`
+	// Speed scales the captured timing, 1 is the original speed, 2 sends the cmds twice as fast.
+	Speed float64 `json:"speed"`
+	// Unpaced ignores the captured timing and sends the cmds as fast as possible.
+	Unpaced bool `json:"unpaced"`
+	// Concurrency is the max number of the cmds in flight, the replay falls behind the captured timing if it is reached.
+	Concurrency int `json:"concurrency"`
+}
+
+func (c *ReplayConfig) init() {
+	if c.Target != nil {
+		c.Target.init()
+	}
+
+	if c.Speed == 0 {
+		c.Speed = defaultReplaySpeed
+	}
+
+	if c.Concurrency == 0 {
+		c.Concurrency = defaultReplayConcurrency
+	}
+}
+
+func (c *ReplayConfig) validate() error {
+	if c.Target == nil || c.CaptureFile == "" {
+		return fmt.Errorf("both target and capture file are required for replay")
+	}
+
+	if err := c.Target.validate(); err != nil {
+		return err
+	}
+
+	if c.Speed < 0 || c.Concurrency < 0 {
+		return fmt.Errorf("speed and concurrency of replay can't be negative")
+	}
+
 	return nil
 }
 
@@ -138,9 +536,108 @@ type ClientConfig struct {
 	ReadMode ReadMode `json:"readMode"`
 
 	// For dual write scenarios, this option is for only routing the non-readonly cmds to the new cluster to reduce traffic.
-	// Only support ignore read-only cmds routing to the new cluster in Do method.
+	// The read-only cmds of Do and Pipeline are decided by the command cache, the scripts of Run by NewReadOnlyScript.
+	// The read-only cmds of a mixed pipeline are removed from the mirrored pipeline.
 	// Enable this option will affect the prod Redis's request routing.
 	IgnoreReadOnly bool `json:"ignoreReadOnly"`
+
+	// SyncWrite makes the connector write to this load test client together with the main client instead of queueing the write.
+	// The call only returns after the write to this client is done. Read-only cmds are not sent to a sync write client.
+	// For load test clients only.
+	SyncWrite bool `json:"syncWrite"`
+	// SyncWritePolicy decides what to do when the write to this load test client fails, could be SyncWriteFail, SyncWriteLog or SyncWriteRetry.
+	SyncWritePolicy SyncWritePolicy `json:"syncWritePolicy"`
+	// SyncWriteMaxRetries is the max number of retries for SyncWriteRetry before failing the call, 0 disables the retries.
+	// It defaults to 3 if unset.
+	SyncWriteMaxRetries *int `json:"syncWriteMaxRetries"`
+	// SyncWriteRetryBackoffInMs is the time to wait between two retries for SyncWriteRetry.
+	SyncWriteRetryBackoffInMs int `json:"syncWriteRetryBackoffInMs"`
+
+	// SampleRate is the ratio (0 to 1) of the requests mirrored to this load test client, all the requests are mirrored if it is 0.
+	// For load test clients only.
+	SampleRate float64 `json:"sampleRate"`
+	// SampleMode specifies how the requests are sampled, could be SampleRandom or SampleByKeyHash.
+	// With SampleByKeyHash, the cmds of a pipeline are sampled by their own keys and the cmds without key are always mirrored.
+	SampleMode SampleMode `json:"sampleMode"`
+
+	// MirrorMaxRetries is the max number of retries of a failed async mirror write, the write is sent to the dead letter sink
+	// if it still fails. The error replies of redis, e.g. WRONGTYPE, are not retried. For load test clients only.
+	MirrorMaxRetries int `json:"mirrorMaxRetries"`
+	// MirrorRetryBackoffInMs is the backoff of the first retry, it is doubled for each retry.
+	MirrorRetryBackoffInMs int `json:"mirrorRetryBackoffInMs"`
+	// MirrorRetryMaxBackoffInMs is the max backoff between two retries.
+	MirrorRetryMaxBackoffInMs int `json:"mirrorRetryMaxBackoffInMs"`
+
+	// MaxOpsPerSecond caps the async mirror requests, the shadow reads and the read-through copies sent to this load test
+	// client, the requests over the cap are dropped before being queued. A pipeline counts as its number of cmds, and a batch
+	// larger than MaxOpsBurst is charged in full against the following requests. It is unlimited if it is 0. For load test clients only.
+	MaxOpsPerSecond float64 `json:"maxOpsPerSecond"`
+	// MaxOpsBurst is the max number of requests allowed at once, it is MaxOpsPerSecond (at least 1) by default.
+	MaxOpsBurst int `json:"maxOpsBurst"`
+
+	// AmplificationFactor sends each async mirror request N times to this load test client for capacity tests,
+	// the copies count in MaxOpsPerSecond, publishes are sent once. For load test clients only.
+	AmplificationFactor int `json:"amplificationFactor"`
+	// AmplificationKeySuffix is appended with the copy number to the keys of the extra copies, e.g. ":copy" makes user:1
+	// user:1:copy1, so the copies don't overwrite each other. The hash tag is kept, so multi-key cmds stay in one slot.
+	// The copies are sent as they are if it is empty.
+	AmplificationKeySuffix string `json:"amplificationKeySuffix"`
+
+	// KeyRewriteRules rewrite the keys of the requests sent to this load test client in order, e.g. to add the hash tags
+	// of a new key schema. The keys are found by the key specs of the cmds, and the keys of Run are the first KeyCount args.
+	// The sampling and the mirror rules are applied to the original keys. Backfill and Verify apply the rules of the target.
+	// For load test clients only, the first load test client with the rules can't serve the traffic in the migration phases
+	// readFromNew, cutover and readThrough, be the fallback client, or be promoted, as the traffic keeps the original keys.
+	KeyRewriteRules []*KeyRewriteRule `json:"keyRewriteRules"`
+}
+
+// KeyRewriteRule rewrites the keys sent to a load test client.
+type KeyRewriteRule struct {
+	// Action could be RewriteAddPrefix, RewriteStripPrefix, RewriteRegex or RewriteHashTag.
+	Action KeyRewriteAction `json:"action"`
+	// Prefix is added by RewriteAddPrefix or stripped by RewriteStripPrefix.
+	Prefix string `json:"prefix"`
+	// Pattern is the regexp of RewriteRegex, or of RewriteHashTag whose first group is wrapped as the hash tag,
+	// e.g. ^([^:]+): makes tenant1:user:1 {tenant1}:user:1. The default of RewriteHashTag is the part before the first colon.
+	// The keys already having a hash tag are not changed by RewriteHashTag.
+	Pattern string `json:"pattern"`
+	// Replacement of the matches of RewriteRegex, $1 is the first group.
+	Replacement string `json:"replacement"`
+
+	pattern *regexp.Regexp
+}
+
+func (r *KeyRewriteRule) initAndValidate() error {
+	if !r.Action.IsValid() {
+		return fmt.Errorf("key rewrite rule action %s is not valid", r.Action)
+	}
+
+	if r.Pattern == ucmEmptyString {
+		r.Pattern = ""
+	}
+
+	switch r.Action {
+	case RewriteAddPrefix, RewriteStripPrefix:
+		if r.Prefix == "" || r.Prefix == ucmEmptyString {
+			return fmt.Errorf("key rewrite rule %s requires a prefix", r.Action)
+		}
+		return nil
+	case RewriteHashTag:
+		if r.Pattern == "" {
+			r.Pattern = defaultKeyRewriteHashTagPattern
+		}
+	}
+
+	pattern, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return fmt.Errorf("key rewrite rule pattern %s is not valid: %s", r.Pattern, err)
+	}
+	if r.Action == RewriteHashTag && pattern.NumSubexp() == 0 {
+		return fmt.Errorf("key rewrite rule pattern %s has no group for the hash tag", r.Pattern)
+	}
+	r.pattern = pattern
+
+	return nil
 }
 
 func (c *ClientConfig) mode() string {
@@ -193,6 +690,46 @@ func (c *ClientConfig) init() {
 		c.Hystrix.ErrorPercentThreshold = defaultCBErrPercent
 	}
 
+	if c.SyncWritePolicy == "" || c.SyncWritePolicy == ucmEmptyString {
+		c.SyncWritePolicy = defaultSyncWritePolicy
+	}
+
+	if c.SyncWriteMaxRetries == nil {
+		retries := defaultSyncWriteMaxRetries
+		c.SyncWriteMaxRetries = &retries
+	}
+
+	if c.SyncWriteRetryBackoffInMs == 0 {
+		c.SyncWriteRetryBackoffInMs = defaultSyncWriteRetryBackoffInMs
+	}
+
+	if c.SampleMode == "" || c.SampleMode == ucmEmptyString {
+		c.SampleMode = defaultSampleMode
+	}
+
+	if c.AmplificationFactor == 0 {
+		c.AmplificationFactor = 1
+	}
+
+	if c.AmplificationKeySuffix == ucmEmptyString {
+		c.AmplificationKeySuffix = ""
+	}
+
+	if c.MaxOpsBurst == 0 {
+		c.MaxOpsBurst = int(math.Ceil(c.MaxOpsPerSecond))
+		if c.MaxOpsBurst < 1 {
+			c.MaxOpsBurst = 1
+		}
+	}
+
+	if c.MirrorRetryBackoffInMs == 0 {
+		c.MirrorRetryBackoffInMs = defaultMirrorRetryBackoffInMs
+	}
+
+	if c.MirrorRetryMaxBackoffInMs == 0 {
+		c.MirrorRetryMaxBackoffInMs = defaultMirrorRetryMaxBackoffInMs
+	}
+
 }
 
 func (c *ClientConfig) validate() error {
@@ -208,6 +745,44 @@ func (c *ClientConfig) validate() error {
 		return fmt.Errorf("read mode %s is not valid", c.ClientMode)
 	}
 
+	if !c.SyncWritePolicy.IsValid() {
+		return fmt.Errorf("sync write policy %s is not valid", c.SyncWritePolicy)
+	}
+
+	if *c.SyncWriteMaxRetries < 0 {
+		return fmt.Errorf("sync write max retries %d is not valid", *c.SyncWriteMaxRetries)
+	}
+
+	if !c.SampleMode.IsValid() {
+		return fmt.Errorf("sample mode %s is not valid", c.SampleMode)
+	}
+
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return fmt.Errorf("sample rate %v is not valid", c.SampleRate)
+	}
+
+	if c.MaxOpsPerSecond < 0 || c.MaxOpsBurst < 0 {
+		return fmt.Errorf("max ops per second %v and burst %d are not valid", c.MaxOpsPerSecond, c.MaxOpsBurst)
+	}
+
+	if c.AmplificationFactor < 1 {
+		return fmt.Errorf("amplification factor %d is not valid", c.AmplificationFactor)
+	}
+
+	for _, rule := range c.KeyRewriteRules {
+		if err := rule.initAndValidate(); err != nil {
+			return err
+		}
+	}
+
+	if c.MirrorMaxRetries < 0 {
+		return fmt.Errorf("mirror max retries %d is not valid", c.MirrorMaxRetries)
+	}
+
+	if c.MirrorRetryBackoffInMs < 0 || c.MirrorRetryMaxBackoffInMs < c.MirrorRetryBackoffInMs {
+		return fmt.Errorf("mirror retry backoff %d and max backoff %d are not valid", c.MirrorRetryBackoffInMs, c.MirrorRetryMaxBackoffInMs)
+	}
+
 	return nil
 }
 
@@ -231,6 +806,29 @@ func (c *ClientConfig) validateReload(config *ClientConfig) error {
 	return nil
 }
 
+// requiresNewClient returns whether the config change can't be reloaded in place, the client is replaced instead
+func (c *ClientConfig) requiresNewClient(config *ClientConfig) bool {
+	return c.ClientMode != config.ClientMode || c.DB != config.DB || !isAddrsEquals(c.Addrs, config.Addrs)
+}
+
+// mirrorsAll returns whether all the traffic is mirrored to the load test client, i.e. it is not sampled, rate limited or
+// filtered by a mirror rule
+func (c *ClientConfig) mirrorsAll(rules []*MirrorRule) bool {
+	if (c.SampleRate > 0 && c.SampleRate < 1) || c.MaxOpsPerSecond > 0 {
+		return false
+	}
+	for _, rule := range rules {
+		if !rule.appliesTo(c.name()) {
+			continue
+		}
+		// only an allow rule matching everything keeps all the traffic
+		if rule.Action != MirrorAllow || len(rule.Commands) > 0 || (rule.KeyPattern != "" && rule.KeyPattern != ucmEmptyString) {
+			return false
+		}
+	}
+	return true
+}
+
 func (c *ClientConfig) createClient(cbOptions []circuitbreaker.Option) (clientWrapper, error) {
 	switch c.ClientMode {
 	default:
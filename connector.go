@@ -30,22 +30,36 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/myteksi/hystrix-go/hystrix"
 	"github.com/pkg/errors"
+	"go.uber.org/atomic"
 
 	"github.com/grab/grab-redis/circuitbreaker"
 	"github.com/grab/grab-redis/redisapi"
 )
 
 type connectorImpl struct {
-	client          *clientImpl
-	loadTestClients []*clientImpl
-
-	processAllLoadTestPackets bool
-	schedulerOptions          *schedulerOptions
-	loadTestScheduler         *scheduler
-	schedulerCancel           context.CancelFunc
+	// routing is the *routing of the cmds, a new one is stored by Promote and reload
+	routing atomic.Value
+	// swapMu serializes reload and Promote
+	swapMu sync.Mutex
+	// promoted is set by Promote until the config is updated to the new main client
+	promoted bool
+
+	schedulerOptions  *schedulerOptions
+	loadTestScheduler *scheduler
+	schedulerCtx      context.Context
+	schedulerCancel   context.CancelFunc
+	spool             *spool
+	deadLetterSink    DeadLetterSink
+	deadLetterFile    *FileDeadLetterSink
+	capturer          *capturer
+	// fallback is the fallback client created by the connector, it is nil if a load test client is the fallback client
+	fallback     *clientImpl
+	fallbackName string
 
 	configurer Configurer
 	stats      StatsClient
@@ -53,6 +67,65 @@ type connectorImpl struct {
 	cbOptions  []circuitbreaker.Option
 }
 
+// routing is the main client, the load test clients, the phase and the hot-reloadable settings of the cmds. It isn't
+// changed once stored, Promote and reload store a new one, so each cmd routes by the one it loaded when it started.
+type routing struct {
+	client                    *clientImpl
+	loadTestClients           []*clientImpl
+	phase                     MigrationPhase
+	processAllLoadTestPackets bool
+	shadowRead                bool
+	shadowReadLogSampleRate   float64
+	mirrorRules               []*MirrorRule
+	fallbackWrites            bool
+
+	// inflight is the number of the cmds routed by it, the clients removed by a swap are shut down once it drops to 0
+	inflight atomic.Int64
+}
+
+// newRouting returns the routing of the config with the clients
+func newRouting(config *ConnectorConfig, client *clientImpl, loadTestClients []*clientImpl) *routing {
+	return &routing{
+		client:                    client,
+		loadTestClients:           loadTestClients,
+		phase:                     config.MigrationPhase,
+		processAllLoadTestPackets: config.ProcessAllLoadTestPackets,
+		shadowRead:                config.ShadowRead,
+		shadowReadLogSampleRate:   *config.ShadowReadLogSampleRate,
+		mirrorRules:               config.MirrorRules,
+		fallbackWrites:            config.FallbackWrites,
+	}
+}
+
+// loadRouting returns the current routing without counting a cmd in flight, e.g. for the load test tasks and the
+// metrics
+func (c *connectorImpl) loadRouting() *routing {
+	return c.routing.Load().(*routing)
+}
+
+// acquire returns the current routing counting the cmd in flight until it calls release
+func (c *connectorImpl) acquire() *routing {
+	for {
+		r := c.loadRouting()
+		r.inflight.Inc()
+		// the routing is swapped in the meantime, the swap may have seen no cmd in flight
+		if c.loadRouting() == r {
+			return r
+		}
+		r.inflight.Dec()
+	}
+}
+
+// release marks the cmd routed by the routing done
+func (r *routing) release() {
+	r.inflight.Dec()
+}
+
+// drained returns whether the cmds routed by the routing are done
+func (r *routing) drained() bool {
+	return r.inflight.Load() == 0
+}
+
 func NewStaticConnector(ctx context.Context, config *ConnectorConfig, options ...ConnectorOption) (redisapi.Client, error) {
 	return NewDynamicConnector(ctx, newStaticConfigurer(config), options...)
 }
@@ -66,6 +139,8 @@ func NewDynamicConnector(ctx context.Context, configurer Configurer, options ...
 		},
 
 		configurer: configurer,
+		stats:      NewNoopStatsClient(),
+		logger:     NewNoopLogger(),
 		cbOptions:  getDefaultCBOptions(),
 	}
 
@@ -83,18 +158,28 @@ func NewDynamicConnector(ctx context.Context, configurer Configurer, options ...
 		return nil, err
 	}
 
-	c.client, err = newClient(ctx, config.Main, ClientStatsD(c.stats), ClientLogger(c.logger), ClientCBOptions(c.cbOptions))
+	main, err := newClient(ctx, config.Main, ClientStatsD(c.stats), ClientLogger(c.logger), ClientCBOptions(c.cbOptions))
 	if err != nil {
 		return nil, err
 	}
 
-	c.loadTestClients = make([]*clientImpl, len(config.LoadTests))
+	loadTestClients := make([]*clientImpl, len(config.LoadTests))
 	for i, config := range config.LoadTests {
-		c.loadTestClients[i], err = newClient(ctx, config, ClientStatsD(c.stats), ClientLogger(c.logger), ClientCBOptions(c.cbOptions))
+		loadTestClients[i], err = newClient(ctx, config, ClientStatsD(c.stats), ClientLogger(c.logger), ClientCBOptions(c.cbOptions))
 		if err != nil {
 			return nil, err
 		}
 	}
+	c.routing.Store(newRouting(config, main, loadTestClients))
+	if config.Fallback != nil {
+		c.fallbackName = config.Fallback.name()
+		if c.fallbackClient(c.loadRouting()) == nil {
+			c.fallback, err = newClient(ctx, config.Fallback, ClientStatsD(c.stats), ClientLogger(c.logger), ClientCBOptions(c.cbOptions))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
 	// The callback function which is used to reload the configuration dynamically
 	c.configurer.OnChange(func() error {
 		connectorOptions := &ConnectorConfig{}
@@ -107,15 +192,42 @@ func NewDynamicConnector(ctx context.Context, configurer Configurer, options ...
 		return c.reload(ctx, connectorOptions)
 	})
 
-	c.processAllLoadTestPackets = config.ProcessAllLoadTestPackets
-	c.schedulerOptions.maxChanSize = config.SchedulerChannelSize
-	c.schedulerOptions.maxWorker = config.SchedulerWorkerNumber
-	c.schedulerOptions.workerIdleTimeout = parseDurationInMs(config.SchedulerWorkerIdleTimeoutInMs)
+	c.schedulerOptions = newSchedulerOptions(config)
 	c.loadTestScheduler = newScheduler(c.schedulerOptions)
+	c.loadTestScheduler.onPanic = c.onSchedulerPanic
+
+	if c.deadLetterSink == nil && config.DeadLetterFile != "" {
+		c.deadLetterFile, err = NewFileDeadLetterSink(config.DeadLetterFile)
+		if err != nil {
+			return nil, err
+		}
+		c.deadLetterSink = c.deadLetterFile
+	}
+
+	if config.SpoolDir != "" {
+		c.spool, err = newSpool(config.SpoolDir, int64(config.SpoolMaxSizeInMB)<<20)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.CaptureFile != "" {
+		c.capturer, err = newCapturer(config)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	schedulerCtx, cancel := context.WithCancel(ctx)
 	go c.loadTestScheduler.start(schedulerCtx)
-	c.schedulerCancel = cancel
+	go c.monitorScheduler(schedulerCtx, reportInterval)
+	if c.spool != nil {
+		go c.replaySpool(schedulerCtx)
+	}
+	if c.capturer != nil {
+		go c.writeCapture(schedulerCtx)
+	}
+	c.schedulerCtx, c.schedulerCancel = schedulerCtx, cancel
 
 	return c, nil
 }
@@ -124,93 +236,321 @@ func (c *connectorImpl) reload(ctx context.Context, config *ConnectorConfig) err
 	if !config.HotReload {
 		return nil
 	}
+	c.swapMu.Lock()
+	defer c.swapMu.Unlock()
 
-	c.processAllLoadTestPackets = config.ProcessAllLoadTestPackets
-	if c.schedulerOptions.maxWorker != config.SchedulerWorkerNumber || c.schedulerOptions.maxChanSize != config.SchedulerChannelSize || c.schedulerOptions.workerIdleTimeout != parseDurationInMs(config.SchedulerWorkerIdleTimeoutInMs) {
-		return fmt.Errorf("dual write worker number/channel size change is not allowed in reloading")
+	// everything is validated and the new clients are created before anything is changed, so a failed reload keeps the
+	// old config as a whole
+	if err := c.validateReload(config); err != nil {
+		c.logger.Warn(pkgName, "unable to reload connector, using back old clients, Error: %s", err)
+		return err
 	}
 
 	var err error
+	var main *clientImpl
+	current := c.loadRouting()
 
 	// TODO: send config to Doorman
 
-	if err = c.client.reload(config.Main); err != nil {
-		c.logger.Warn(pkgName, "unable to reload client, using back old client, Error: %s", err)
-		return err
+	if current.client.config.requiresNewClient(config.Main) {
+		if main, err = c.newMainClient(ctx, config.Main); err != nil {
+			c.logger.Warn(pkgName, "unable to reload client, using back old client, Error: %s", err)
+			return err
+		}
 	}
 
 	loadTestMap := make(map[string][]*clientImpl)
-	for _, client := range c.loadTestClients {
+	for _, client := range current.loadTestClients {
 		loadTestMap[client.config.name()] = append(loadTestMap[client.config.name()], client)
 	}
 
+	// the kept clients are reloaded in place after the new ones are all created
 	newLoadTestClients := make([]*clientImpl, len(config.LoadTests))
+	var reloaded []int
+	created := make([]*clientImpl, 0, len(config.LoadTests)+1)
+	if main != nil {
+		created = append(created, main)
+	}
 	for i, config := range config.LoadTests {
-		var client *clientImpl
-
-		clients := loadTestMap[config.name()]
-		if len(clients) > 0 {
-			client, loadTestMap[config.name()] = clients[len(clients)-1], clients[:len(clients)-1]
-			if isAddrsEquals(c.client.config.Addrs, config.Addrs) {
-				c.logger.Warn(pkgName, "unable to reload load test client, fallback to old client, Error: %s", err)
-				return fmt.Errorf("can't share the same address with the main client")
-			}
-			if err = client.reload(config); err != nil {
-				c.logger.Warn(pkgName, "unable to reload load test client, fallback to old client, Error: %s", err)
-				return err
-			}
-		} else {
-			client, err = newClient(ctx, config, ClientStatsD(c.stats), ClientLogger(c.logger), ClientCBOptions(c.cbOptions))
-			if err != nil {
-				c.logger.Warn(pkgName, "unable to create new load test client, Error: %s", err)
-				return err
-			}
+		if clients := loadTestMap[config.name()]; len(clients) > 0 {
+			newLoadTestClients[i], loadTestMap[config.name()] = clients[len(clients)-1], clients[:len(clients)-1]
+			reloaded = append(reloaded, i)
+			continue
 		}
 
+		client, err := newClient(ctx, config, ClientStatsD(c.stats), ClientLogger(c.logger), ClientCBOptions(c.cbOptions))
+		if err != nil {
+			c.logger.Warn(pkgName, "unable to reload load test client, fallback to old client, Error: %s", err)
+			for _, client := range created {
+				client.ShutDown(ctx)
+			}
+			return err
+		}
 		newLoadTestClients[i] = client
+		created = append(created, client)
+	}
+
+	// the in place reloads don't fail once the config changes are validated
+	if main == nil {
+		if err := current.client.reload(config.Main); err != nil {
+			c.logger.Warn(pkgName, "unable to reload client, Error: %s", err)
+		}
+	}
+	for _, i := range reloaded {
+		if err := newLoadTestClients[i].reload(config.LoadTests[i]); err != nil {
+			c.logger.Warn(pkgName, "unable to reload load test client, Error: %s", err)
+		}
 	}
 
+	c.promoted = false
+	options := newSchedulerOptions(config)
+	options.normalise()
+	if *c.schedulerOptions != *options {
+		c.schedulerOptions = options
+		c.loadTestScheduler.resize(c.schedulerCtx, c.schedulerOptions)
+	}
+
+	// the removed load test clients may still be referenced by the cmds in flight and the queued mirror writes
+	var retired []*clientImpl
 	for _, clients := range loadTestMap {
-		for _, client := range clients {
-			client.ShutDown(ctx)
+		retired = append(retired, clients...)
+	}
+	if main == nil {
+		c.routing.Store(newRouting(config, current.client, newLoadTestClients))
+		c.retire(current, retired...)
+		return nil
+	}
+	c.routing.Store(newRouting(config, main, newLoadTestClients))
+	c.stats.Count1(pkgName, metricReplaced, main.getTags(tagFunctionPromote))
+	c.logger.Info(pkgName, "replaced main client %s with %s", current.client.config.name(), main.config.name())
+	// the old main client may still receive the cmds in flight and the queued mirror writes in the cutover phase
+	c.retire(current, append(retired, current.client)...)
+	return nil
+}
+
+// validateReload checks the config changes which can't be reloaded
+func (c *connectorImpl) validateReload(config *ConnectorConfig) error {
+	current := c.loadRouting()
+	if err := validatePhaseTransition(current.phase, config.MigrationPhase); err != nil {
+		return err
+	}
+
+	options := newSchedulerOptions(config)
+	options.normalise()
+	if c.schedulerOptions.orderedByKey != options.orderedByKey {
+		return fmt.Errorf("dual write ordering change is not allowed in reloading")
+	}
+
+	// the config has the old main client until it's updated to the promoted one
+	if c.promoted && current.client.config.requiresNewClient(config.Main) {
+		return fmt.Errorf("main client is promoted to %s, the config must be updated before reloading", current.client.config.name())
+	}
+	if !current.client.config.requiresNewClient(config.Main) {
+		if err := current.client.config.validateReload(config.Main); err != nil {
+			return err
 		}
 	}
-	c.loadTestClients = newLoadTestClients
 
+	fallbackName := ""
+	if config.Fallback != nil {
+		fallbackName = config.Fallback.name()
+	}
+	if fallbackName != c.fallbackName {
+		return fmt.Errorf("fallback change is not allowed in reloading")
+	}
+
+	loadTestConfigs := make(map[string]*ClientConfig)
+	for _, client := range current.loadTestClients {
+		loadTestConfigs[client.config.name()] = client.config
+	}
+	// the load test client serving as the fallback client can't be removed
+	servesFallback := c.fallbackName != "" && c.fallback == nil
+	for _, loadTest := range config.LoadTests {
+		if loadTest.name() == c.fallbackName {
+			servesFallback = false
+		}
+		if isAddrsEquals(config.Main.Addrs, loadTest.Addrs) {
+			return fmt.Errorf("can't share the same address with the main client")
+		}
+		if old, ok := loadTestConfigs[loadTest.name()]; ok {
+			if err := old.validateReload(loadTest); err != nil {
+				return err
+			}
+		}
+	}
+	if servesFallback {
+		return fmt.Errorf("removing the load test client %s serving as the fallback client is not allowed in reloading", c.fallbackName)
+	}
 	return nil
 }
 
-func (c *connectorImpl) queueLoadTest(fn func(context.Context, *clientImpl)) {
-	for _, client := range c.loadTestClients {
-		if c.processAllLoadTestPackets {
-			c.loadTestScheduler.fnChan <- func(ctx context.Context) {
+// loadTestFunc sends the same request as the main client to a load test client
+type loadTestFunc func(ctx context.Context, client *clientImpl) error
+
+func (c *connectorImpl) queueLoadTest(r *routing, req *loadTestRequest) {
+	// an empty pipeline has nothing to mirror
+	if req.function == tagFunctionPipeline && len(req.cmds) == 0 {
+		return
+	}
+	for _, client := range r.mirrorClients() {
+		if client.config.SyncWrite {
+			continue
+		}
+		sampled := c.requestFor(r, client, req)
+		if sampled == nil {
+			continue
+		}
+		copies := c.amplify(client, sampled)
+		if !c.allowOps(client, sampled.ops()*len(copies)) {
+			continue
+		}
+		for _, amplified := range copies {
+			amplified := amplified
+			c.queue(r, client, func(ctx context.Context, client *clientImpl) error {
+				return c.mirror(ctx, client, amplified)
+			}, amplified)
+		}
+	}
+}
+
+// allowOps takes n ops from MaxOpsPerSecond of the load test client, the request is counted as dropped if it is over the cap
+func (c *connectorImpl) allowOps(client *clientImpl, n int) bool {
+	if client.config.MaxOpsPerSecond <= 0 || client.opsLimiter.allow(n) {
+		return true
+	}
+	c.stats.Count1(pkgName, metricDropped, client.getTags(tagFunctionRateLimit))
+	return false
+}
+
+// errLoadTestQueueFull is the error of the dead letters of the writes dropped by a full lane
+var errLoadTestQueueFull = errors.New("load test queue is full")
+
+// queue sends the fn to the load test scheduler, the fn is dropped if the queue is full, unless processAllLoadTestPackets of
+// the routing is enabled.
+// The req of the fn is spooled instead of being dropped if the spool is enabled. When the scheduler is ordered by key, the
+// first key of the req decides the lane of the fn, and a write dropped by a full lane goes to the dead letter sink.
+func (c *connectorImpl) queue(r *routing, client *clientImpl, fn loadTestFunc, req *loadTestRequest) {
+	enqueued := time.Now()
+	task := func(ctx context.Context) {
+		c.stats.Duration(pkgName, metricLag, enqueued, client.getTags(tagFunctionScheduler)...)
+		select {
+		case <-ctx.Done(): // This case is executed if ctx is cancelled
+			c.logger.Error(pkgName, "Context cancelled before load test could be carried out.")
+			return
+		default:
+			_ = fn(ctx, client)
+		}
+	}
+
+	key, hasKey := req.firstKey(r.client)
+	if c.loadTestScheduler.send(key, hasKey, task, r.processAllLoadTestPackets) {
+		return
+	}
+
+	// a spooled write would be replayed after the newer writes of the same key, e.g. a SET after the DEL of the key
+	if c.loadTestScheduler.ordered() && !req.readonly {
+		c.stats.Count1(pkgName, metricError, client.getTags(tagFunctionQueueLoadTest))
+		c.deadLetter(context.Background(), client, req, errLoadTestQueueFull)
+		return
+	}
+	if c.spoolRequest(client, req) {
+		return
+	}
+	c.stats.Count1(pkgName, metricError, client.getTags(tagFunctionQueueLoadTest))
+	c.logger.Error(pkgName, "load test queue is full (current queue size: %d), dropping load test request", c.loadTestScheduler.backlog())
+}
+
+// onSchedulerPanic reports the panic of a load test task, the production path is not affected
+func (c *connectorImpl) onSchedulerPanic(recovered interface{}, stack []byte) {
+	c.stats.Count1(pkgName, metricPanic, c.mainClient().getTags(tagFunctionScheduler))
+	c.logger.Error(pkgName, "load test task panicked: %v\n%s", recovered, stack)
+}
+
+// monitorScheduler reports the backlog, the workers and the execution latency of the load test scheduler
+func (c *connectorImpl) monitorScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s := c.loadTestScheduler
+			tags := c.mainClient().getTags(tagFunctionScheduler)
+			c.stats.Gauge("redis.scheduler", metricBacklog, float64(s.backlog()), tags)
+			c.stats.Gauge("redis.scheduler", metricCapacity, float64(s.capacity()), tags)
+			c.stats.Gauge("redis.scheduler", metricActive, float64(s.numWorker.Load()), tags)
+			// the latencies are in nanoseconds, reported in milliseconds
+			c.stats.Gauge("redis.scheduler", metricLatency, s.latencies.Average()/1e6, tags)
+			percentiles := s.latencies.Percentiles(0.5, 0.95, 0.99)
+			c.stats.Gauge("redis.scheduler", metricLatencyP50, percentiles[0]/1e6, tags)
+			c.stats.Gauge("redis.scheduler", metricLatencyP95, percentiles[1]/1e6, tags)
+			c.stats.Gauge("redis.scheduler", metricLatencyP99, percentiles[2]/1e6, tags)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// syncLoadTest sends the request to the load test clients with SyncWrite enabled and waits for the replies.
+// The error is only returned when the policy of the failed client is not SyncWriteLog.
+func (c *connectorImpl) syncLoadTest(ctx context.Context, r *routing, req *loadTestRequest) error {
+	for _, client := range r.mirrorClients() {
+		if !client.config.SyncWrite {
+			continue
+		}
+		sampled := c.requestFor(r, client, req)
+		if sampled == nil {
+			continue
+		}
+		fn := sampled.execute
+
+		err := fn(ctx, client)
+		if err != nil && client.config.SyncWritePolicy == SyncWriteRetry {
+			backoff := parseDurationInMs(client.config.SyncWriteRetryBackoffInMs)
+			for i := 0; i < *client.config.SyncWriteMaxRetries && err != nil; i++ {
 				select {
-				case <-ctx.Done(): // This case is executed if ctx is cancelled
-					c.logger.Error(pkgName, "Context cancelled before load test could be carried out.")
-					return
-				default:
-					fn(ctx, client)
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(backoff):
 				}
+				err = fn(ctx, client)
 			}
+		}
+		if err == nil {
 			continue
 		}
 
-		select {
-		case c.loadTestScheduler.fnChan <- func(ctx context.Context) {
-			select {
-			case <-ctx.Done(): // This case is executed if ctx is cancelled
-				c.logger.Error(pkgName, "Context cancelled before load test could be carried out.")
-				return
-			default:
-				fn(ctx, client)
-			}
-		}:
+		c.stats.Count1(pkgName, metricError, client.getTags(tagFunctionSyncLoadTest))
+		if client.config.SyncWritePolicy == SyncWriteLog {
+			c.logger.Warn(pkgName, "sync write to load test client %s failed, Error: %s", client.config.name(), err)
+			continue
+		}
 
-		default:
-			c.stats.Count1(pkgName, metricError, client.getTags(tagFunctionQueueLoadTest))
-			c.logger.Error(pkgName, "load test queue is full (current queue size: %d), dropping load test request", len(c.loadTestScheduler.fnChan))
+		c.logger.Error(pkgName, "sync write to load test client %s failed, Error: %s", client.config.name(), err)
+		return errors.Wrapf(err, "sync write to load test client %s failed", client.config.name())
+	}
+
+	return nil
+}
+
+// succeededRequest returns the pipeline request of the cmds succeeded on the main client to be sync written, or nil if
+// there is no succeeded write. The read-only cmds are left out if they are not mirrored.
+func (c *connectorImpl) succeededRequest(r *routing, argsList [][]interface{}, replies []redisapi.ReplyPair) *loadTestRequest {
+	succeeded := make([][]interface{}, 0, len(argsList))
+	for i, args := range argsList {
+		if i < len(replies) && replies[i].Err == nil {
+			succeeded = append(succeeded, args)
 		}
 	}
+
+	writes := r.client.writeCmds(succeeded)
+	if len(writes) == 0 {
+		return nil
+	}
+	if r.ignoreReadOnly() {
+		return newPipelineRequest(writes)
+	}
+	return newPipelineRequest(succeeded)
 }
 
 func logHystrixError(connector *connectorImpl, err error) {
@@ -218,19 +558,19 @@ func logHystrixError(connector *connectorImpl, err error) {
 		return
 	}
 
-	connector.stats.Count1(pkgName, metricError, connector.client.getTags(tagHystrixError))
+	connector.stats.Count1(pkgName, metricError, connector.mainClient().getTags(tagHystrixError))
 	switch e := errors.Cause(err); e {
 	case hystrix.ErrTimeout:
 		// handle timeout error
-		connector.stats.Count1(pkgName, metricError, connector.client.getTags(tagHystrixTimeout))
+		connector.stats.Count1(pkgName, metricError, connector.mainClient().getTags(tagHystrixTimeout))
 		connector.logger.Warn(pkgName, "hystrix timeout error: %s", err)
 	case hystrix.ErrCircuitOpen:
 		// handle circuit open error
-		connector.stats.Count1(pkgName, metricError, connector.client.getTags(tagHystrixCircuitOpen))
+		connector.stats.Count1(pkgName, metricError, connector.mainClient().getTags(tagHystrixCircuitOpen))
 		connector.logger.Warn(pkgName, "hystrix circuit open error: %s", err)
 	case hystrix.ErrMaxConcurrency:
 		// handle max concurrency error
-		connector.stats.Count1(pkgName, metricError, connector.client.getTags(tagHystrixMaxConcurrency))
+		connector.stats.Count1(pkgName, metricError, connector.mainClient().getTags(tagHystrixMaxConcurrency))
 		connector.logger.Warn(pkgName, "hystrix max concurrency error: %s", err)
 	default:
 		// handle other hystrix errors
@@ -240,111 +580,331 @@ func logHystrixError(connector *connectorImpl, err error) {
 
 // Do sends a redis command to a read and write enabled node
 func (c *connectorImpl) Do(ctx context.Context, cmdName string, args ...interface{}) (interface{}, error) {
-	if c.client.config.IgnoreReadOnly {
-		readonly, _ := c.client.ifCommandReadonly(cmdName)
-		if readonly {
-			return c.client.Do(ctx, cmdName, args...)
+	// the command cache is keyed by the lowercase names
+	cmdName = strings.ToLower(cmdName)
+	r := c.acquire()
+	defer r.release()
+	if c.capturer != nil {
+		defer c.capture(newDoRequest(cmdName, args), time.Now())
+	}
+	readonly, _ := r.client.ifCommandReadonly(cmdName)
+	if readonly && r.isShadowRead() {
+		value, err := r.readClient().Do(ctx, cmdName, args...)
+		logHystrixError(c, err)
+		if err == nil {
+			c.queueShadowRead(r, cmdName, args, value)
+		} else if fallback := c.fallbackFor(r, r.readClient(), err, true); fallback != nil {
+			return fallback.Do(ctx, cmdName, args...)
 		}
+		return value, err
 	}
-	c.queueLoadTest(func(ctx context.Context, client *clientImpl) {
-		_, _ = client.Do(ctx, cmdName, args...)
-	})
+	if readonly && r.ignoreReadOnly() {
+		value, err := r.readClient().Do(ctx, cmdName, args...)
+		if fallback := c.fallbackFor(r, r.readClient(), err, true); fallback != nil {
+			return fallback.Do(ctx, cmdName, args...)
+		}
+		if err == nil && value == nil && r.isReadThrough() {
+			return c.readThrough(ctx, r, cmdName, args)
+		}
+		return value, err
+	}
+	loadTest := newDoRequest(cmdName, args)
+	loadTest.readonly = readonly
+	if r.isReadThrough() {
+		if err := c.deleteOld(ctx, r, loadTest); err != nil {
+			return nil, err
+		}
+	}
+	c.queueLoadTest(r, loadTest)
 
-	value, err := c.client.Do(ctx, cmdName, args...)
+	value, err := r.writeClient().Do(ctx, cmdName, args...)
 	logHystrixError(c, err)
+	if fallback := c.fallbackFor(r, r.writeClient(), err, readonly); fallback != nil {
+		value, err = fallback.Do(ctx, cmdName, args...)
+	}
+	if err == nil && !readonly {
+		err = c.syncLoadTest(ctx, r, loadTest)
+	}
 	return value, err
 }
 
 // DoReadOnly doesn't only execute cmds on a read only node, it's the same function as Do
 // Keeping this function for backward compatibility
 func (c *connectorImpl) DoReadOnly(ctx context.Context, cmdName string, args ...interface{}) (interface{}, error) {
-	if c.client.config.IgnoreReadOnly {
-		readonly, _ := c.client.ifCommandReadonly(cmdName)
-		if readonly {
-			return c.client.DoReadOnly(ctx, cmdName, args...)
+	// the command cache is keyed by the lowercase names
+	cmdName = strings.ToLower(cmdName)
+	r := c.acquire()
+	defer r.release()
+	if c.capturer != nil {
+		defer c.capture(newDoRequest(cmdName, args), time.Now())
+	}
+	readonly, _ := r.client.ifCommandReadonly(cmdName)
+	if readonly && r.isShadowRead() {
+		value, err := r.readClient().DoReadOnly(ctx, cmdName, args...)
+		logHystrixError(c, err)
+		if err == nil {
+			c.queueShadowRead(r, cmdName, args, value)
+		} else if fallback := c.fallbackFor(r, r.readClient(), err, true); fallback != nil {
+			return fallback.DoReadOnly(ctx, cmdName, args...)
 		}
+		return value, err
 	}
-	c.queueLoadTest(func(ctx context.Context, client *clientImpl) {
-		_, _ = client.DoReadOnly(ctx, cmdName, args...)
-	})
+	if readonly && r.ignoreReadOnly() {
+		value, err := r.readClient().DoReadOnly(ctx, cmdName, args...)
+		if fallback := c.fallbackFor(r, r.readClient(), err, true); fallback != nil {
+			return fallback.DoReadOnly(ctx, cmdName, args...)
+		}
+		if err == nil && value == nil && r.isReadThrough() {
+			return c.readThrough(ctx, r, cmdName, args)
+		}
+		return value, err
+	}
+	loadTest := newDoRequest(cmdName, args)
+	loadTest.readonly = readonly
+	if r.isReadThrough() {
+		if err := c.deleteOld(ctx, r, loadTest); err != nil {
+			return nil, err
+		}
+	}
+	c.queueLoadTest(r, loadTest)
 
-	value, err := c.client.DoReadOnly(ctx, cmdName, args...)
+	value, err := r.writeClient().DoReadOnly(ctx, cmdName, args...)
 	logHystrixError(c, err)
+	if fallback := c.fallbackFor(r, r.writeClient(), err, readonly); fallback != nil {
+		value, err = fallback.DoReadOnly(ctx, cmdName, args...)
+	}
+	if err == nil && !readonly {
+		err = c.syncLoadTest(ctx, r, loadTest)
+	}
 	return value, err
 }
 
 // Pipeline sends pipelined redis commands to a read and write enabled node and receives the reply and err
 func (c *connectorImpl) Pipeline(ctx context.Context, argsList [][]interface{}) ([]redisapi.ReplyPair, error) {
-	c.queueLoadTest(func(ctx context.Context, client *clientImpl) {
-		_, _ = client.Pipeline(ctx, argsList)
-	})
+	r := c.acquire()
+	defer r.release()
+	if c.capturer != nil {
+		defer c.capture(newPipelineRequest(argsList), time.Now())
+	}
+	writes := r.client.writeCmds(argsList)
+	if len(writes) == 0 && r.ignoreReadOnly() {
+		value, err := r.readClient().Pipeline(ctx, argsList)
+		if fallback := c.fallbackFor(r, r.readClient(), err, true); fallback != nil {
+			return fallback.Pipeline(ctx, argsList)
+		}
+		if len(value) == len(argsList) && r.isReadThrough() {
+			value = c.readThroughPipeline(ctx, r, argsList, value)
+		}
+		return value, err
+	}
+	loadTest := newPipelineRequest(argsList)
+	if r.ignoreReadOnly() {
+		loadTest = newPipelineRequest(writes)
+	}
+	loadTest.readonly = len(writes) == 0
+	if r.isReadThrough() {
+		if err := c.deleteOld(ctx, r, loadTest); err != nil {
+			return nil, err
+		}
+	}
+	c.queueLoadTest(r, loadTest)
 
-	value, err := c.client.Pipeline(ctx, argsList)
+	value, err := r.writeClient().Pipeline(ctx, argsList)
 	logHystrixError(c, err)
+	if fallback := c.fallbackFor(r, r.writeClient(), err, loadTest.readonly); fallback != nil {
+		value, err = fallback.Pipeline(ctx, argsList)
+	}
+	// an error reply of one cmd doesn't skip the sync write of the others
+	if synced := c.succeededRequest(r, argsList, value); synced != nil {
+		if syncErr := c.syncLoadTest(ctx, r, synced); syncErr != nil {
+			err = syncErr
+		}
+	}
 	return value, err
 }
 
 // PipelineReadOnly doesn't only execute script on a read only node, it's the same function as Pipeline
 // Keeping this function for backward compatibility
 func (c *connectorImpl) PipelineReadOnly(ctx context.Context, argsList [][]interface{}) ([]redisapi.ReplyPair, error) {
-	c.queueLoadTest(func(ctx context.Context, client *clientImpl) {
-		_, _ = client.PipelineReadOnly(ctx, argsList)
-	})
+	r := c.acquire()
+	defer r.release()
+	if c.capturer != nil {
+		defer c.capture(newPipelineRequest(argsList), time.Now())
+	}
+	writes := r.client.writeCmds(argsList)
+	if len(writes) == 0 && r.ignoreReadOnly() {
+		value, err := r.readClient().PipelineReadOnly(ctx, argsList)
+		if fallback := c.fallbackFor(r, r.readClient(), err, true); fallback != nil {
+			return fallback.PipelineReadOnly(ctx, argsList)
+		}
+		if len(value) == len(argsList) && r.isReadThrough() {
+			value = c.readThroughPipeline(ctx, r, argsList, value)
+		}
+		return value, err
+	}
+	loadTest := newPipelineRequest(argsList)
+	if r.ignoreReadOnly() {
+		loadTest = newPipelineRequest(writes)
+	}
+	loadTest.readonly = len(writes) == 0
+	if r.isReadThrough() {
+		if err := c.deleteOld(ctx, r, loadTest); err != nil {
+			return nil, err
+		}
+	}
+	c.queueLoadTest(r, loadTest)
 
-	value, err := c.client.PipelineReadOnly(ctx, argsList)
+	value, err := r.writeClient().PipelineReadOnly(ctx, argsList)
 	logHystrixError(c, err)
+	if fallback := c.fallbackFor(r, r.writeClient(), err, loadTest.readonly); fallback != nil {
+		value, err = fallback.PipelineReadOnly(ctx, argsList)
+	}
+	// an error reply of one cmd doesn't skip the sync write of the others
+	if synced := c.succeededRequest(r, argsList, value); synced != nil {
+		if syncErr := c.syncLoadTest(ctx, r, synced); syncErr != nil {
+			err = syncErr
+		}
+	}
 	return value, err
 }
 
 // Run executes a script on a read and write enable node and receives the reply and err
 func (c *connectorImpl) Run(ctx context.Context, script *redisapi.Script, keysAndArgs ...interface{}) (interface{}, error) {
-	c.queueLoadTest(func(ctx context.Context, client *clientImpl) {
-		_, _ = client.Run(ctx, script, keysAndArgs...)
-	})
-	value, err := c.client.Run(ctx, script, keysAndArgs...)
+	r := c.acquire()
+	defer r.release()
+	if c.capturer != nil {
+		defer c.capture(newRunRequest(script, keysAndArgs), time.Now())
+	}
+	readonly := script.ReadOnly()
+	if readonly && r.ignoreReadOnly() {
+		value, err := r.readClient().Run(ctx, script, keysAndArgs...)
+		if fallback := c.fallbackFor(r, r.readClient(), err, true); fallback != nil {
+			return fallback.Run(ctx, script, keysAndArgs...)
+		}
+		return value, err
+	}
+	loadTest := newRunRequest(script, keysAndArgs)
+	loadTest.readonly = readonly
+	if r.isReadThrough() {
+		if err := c.deleteOld(ctx, r, loadTest); err != nil {
+			return nil, err
+		}
+	}
+	c.queueLoadTest(r, loadTest)
+	value, err := r.writeClient().Run(ctx, script, keysAndArgs...)
 	logHystrixError(c, err)
+	if fallback := c.fallbackFor(r, r.writeClient(), err, readonly); fallback != nil {
+		value, err = fallback.Run(ctx, script, keysAndArgs...)
+	}
+	if err == nil && !readonly {
+		err = c.syncLoadTest(ctx, r, loadTest)
+	}
 	return value, err
 }
 
 // RunReadOnly doesn't only execute script on a read only node, it's the same function as Run
 // Keeping this function for backward compatibility
 func (c *connectorImpl) RunReadOnly(ctx context.Context, script *redisapi.Script, keysAndArgs ...interface{}) (interface{}, error) {
-	c.queueLoadTest(func(ctx context.Context, client *clientImpl) {
-		_, _ = client.RunReadOnly(ctx, script, keysAndArgs...)
-	})
-	value, err := c.client.RunReadOnly(ctx, script, keysAndArgs...)
+	r := c.acquire()
+	defer r.release()
+	if c.capturer != nil {
+		defer c.capture(newRunRequest(script, keysAndArgs), time.Now())
+	}
+	readonly := script.ReadOnly()
+	if readonly && r.ignoreReadOnly() {
+		value, err := r.readClient().RunReadOnly(ctx, script, keysAndArgs...)
+		if fallback := c.fallbackFor(r, r.readClient(), err, true); fallback != nil {
+			return fallback.RunReadOnly(ctx, script, keysAndArgs...)
+		}
+		return value, err
+	}
+	loadTest := newRunRequest(script, keysAndArgs)
+	loadTest.readonly = readonly
+	if r.isReadThrough() {
+		if err := c.deleteOld(ctx, r, loadTest); err != nil {
+			return nil, err
+		}
+	}
+	c.queueLoadTest(r, loadTest)
+	value, err := r.writeClient().RunReadOnly(ctx, script, keysAndArgs...)
 	logHystrixError(c, err)
+	if fallback := c.fallbackFor(r, r.writeClient(), err, readonly); fallback != nil {
+		value, err = fallback.RunReadOnly(ctx, script, keysAndArgs...)
+	}
+	if err == nil && !readonly {
+		err = c.syncLoadTest(ctx, r, loadTest)
+	}
 	return value, err
 }
 
 // Publish publishes to a Redis channel and returns a string or an error
 func (c *connectorImpl) Publish(ctx context.Context, channelName string, value interface{}) (interface{}, error) {
-	c.queueLoadTest(func(ctx context.Context, client *clientImpl) {
-		_, _ = client.Publish(ctx, channelName, value)
-	})
-	value, err := c.client.Publish(ctx, channelName, value)
+	r := c.acquire()
+	defer r.release()
+	loadTest := newPublishRequest(channelName, value)
+	c.queueLoadTest(r, loadTest)
+	reply, err := r.writeClient().Publish(ctx, channelName, value)
 	logHystrixError(c, err)
-	return value, err
+	if fallback := c.fallbackFor(r, r.writeClient(), err, false); fallback != nil {
+		reply, err = fallback.Publish(ctx, channelName, value)
+	}
+	if err == nil {
+		err = c.syncLoadTest(ctx, r, loadTest)
+	}
+	return reply, err
 }
 
 // Subscribe subscribes to Redis channel(s) and return a SubscribeResponse and err
 func (c *connectorImpl) Subscribe(ctx context.Context, chanBufferSize int, channels ...string) (*redisapi.SubscribeResponse, error) {
-	c.queueLoadTest(func(ctx context.Context, client *clientImpl) {
-		_, _ = client.Subscribe(ctx, chanBufferSize, channels...)
-	})
-	value, err := c.client.Subscribe(ctx, chanBufferSize, channels...)
+	r := c.acquire()
+	defer r.release()
+	value, err := r.writeClient().Subscribe(ctx, chanBufferSize, channels...)
 	logHystrixError(c, err)
-	return value, err
+	if err != nil {
+		return value, err
+	}
+
+	clients := c.subscribeMirrorClients(r)
+	if len(clients) == 0 {
+		return value, nil
+	}
+
+	responses := []*redisapi.SubscribeResponse{value}
+	for _, client := range clients {
+		response, err := client.Subscribe(ctx, chanBufferSize, channels...)
+		if err != nil {
+			// the messages of the write client are still delivered
+			c.stats.Count1(pkgName, metricError, client.getTags(tagFunctionSubscribe))
+			c.logger.Warn(pkgName, "failed to subscribe on load test client %s, Error: %s", client.config.name(), err)
+			continue
+		}
+		responses = append(responses, response)
+	}
+	return c.mergeSubscriptions(chanBufferSize, responses), nil
 }
 
 // ShutDown will stop the status reporting, close the pools and other clean up.
 func (c *connectorImpl) ShutDown(ctx context.Context) {
-	c.client.ShutDown(ctx)
+	r := c.loadRouting()
+
+	r.client.ShutDown(ctx)
 	c.schedulerCancel()
 
 	c.loadTestScheduler.wg.Wait()
+	if c.capturer != nil {
+		<-c.capturer.done
+	}
 	// cannot use queueLoadTest to shut down because we're closing the scheduler
-	for _, client := range c.loadTestClients {
+	for _, client := range r.loadTestClients {
 		go client.ShutDown(ctx)
 	}
+	if c.fallback != nil {
+		go c.fallback.ShutDown(ctx)
+	}
+
+	if c.deadLetterFile != nil {
+		if err := c.deadLetterFile.Close(); err != nil {
+			c.logger.Warn(pkgName, "failed to close dead letter file, Error: %s", err)
+		}
+	}
 }
@@ -0,0 +1,115 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	goredis "github.com/grab/redis/v8"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test MirrorRules", func() {
+	var c *connectorImpl
+	var r *routing
+	var loadTest *clientImpl
+
+	rules := func(rules ...*MirrorRule) []*MirrorRule {
+		for _, rule := range rules {
+			Expect(rule.initAndValidate()).To(Succeed())
+		}
+		return rules
+	}
+
+	BeforeEach(func() {
+		r = &routing{
+			client: &clientImpl{
+				cmdCache: map[string]*goredis.CommandInfo{
+					"set":  {Name: "set", FirstKeyPos: 1},
+					"del":  {Name: "del", FirstKeyPos: 1},
+					"ping": {Name: "ping"},
+				},
+			},
+		}
+		c = &connectorImpl{}
+		c.routing.Store(r)
+		loadTest = &clientImpl{config: &ClientConfig{Addrs: []string{"load-test:6379"}}}
+	})
+
+	It("mirrors everything without rules", func() {
+		req := newDoRequest("DEL", []interface{}{"k"})
+		Expect(c.requestFor(r, loadTest, req)).To(Equal(req))
+	})
+
+	It("denies the cmds by name", func() {
+		r.mirrorRules = rules(&MirrorRule{Action: MirrorDeny, Commands: []string{"del"}})
+		Expect(c.requestFor(r, loadTest, newDoRequest("DEL", []interface{}{"k"}))).To(BeNil())
+		Expect(c.requestFor(r, loadTest, newDoRequest("SET", []interface{}{"k", "v"}))).NotTo(BeNil())
+	})
+
+	It("only mirrors the allowed keys", func() {
+		r.mirrorRules = rules(&MirrorRule{Action: MirrorAllow, KeyPattern: "user:*"})
+		Expect(c.requestFor(r, loadTest, newDoRequest("SET", []interface{}{"user:1", "v"}))).NotTo(BeNil())
+		Expect(c.requestFor(r, loadTest, newDoRequest("SET", []interface{}{"order:1", "v"}))).To(BeNil())
+		Expect(c.requestFor(r, loadTest, newDoRequest("PING", nil))).To(BeNil())
+
+		sampled := c.requestFor(r, loadTest, newPipelineRequest([][]interface{}{
+			{"SET", "user:1", "v"},
+			{"SET", "order:1", "v"},
+		}))
+		Expect(sampled.cmds).To(Equal([][]interface{}{{"SET", "user:1", "v"}}))
+	})
+
+	It("applies the first matched rule", func() {
+		r.mirrorRules = rules(
+			&MirrorRule{Action: MirrorDeny, Commands: []string{"DEL"}, KeyPattern: "user:*"},
+			&MirrorRule{Action: MirrorAllow, KeyPattern: "user:*"},
+		)
+		Expect(c.requestFor(r, loadTest, newDoRequest("DEL", []interface{}{"user:1"}))).To(BeNil())
+		Expect(c.requestFor(r, loadTest, newDoRequest("SET", []interface{}{"user:1", "v"}))).NotTo(BeNil())
+	})
+
+	It("only applies the rules to the named load test clients", func() {
+		r.mirrorRules = rules(&MirrorRule{Action: MirrorDeny, LoadTests: []string{"other:6379"}})
+		Expect(c.requestFor(r, loadTest, newDoRequest("SET", []interface{}{"k", "v"}))).NotTo(BeNil())
+	})
+
+	It("converts the glob-style patterns", func() {
+		for pattern, keys := range map[string]map[string]bool{
+			"user:*":   {"user:1": true, "user:": true, "users:1": false},
+			"h?llo":    {"hello": true, "hallo": true, "hllo": false},
+			"h[ae]llo": {"hello": true, "hallo": true, "hillo": false},
+			"h[^e]llo": {"hallo": true, "hello": false},
+			`a\*b`:     {"a*b": true, "aab": false},
+		} {
+			re, err := globToRegexp(pattern)
+			Expect(err).NotTo(HaveOccurred())
+			for key, matched := range keys {
+				Expect(re.MatchString(key)).To(Equal(matched), pattern+" "+key)
+			}
+		}
+	})
+})
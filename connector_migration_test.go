@@ -0,0 +1,332 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/grab/grab-redis/redisapi"
+)
+
+var _ = Describe("validatePhaseTransition", func() {
+	It("allows the legal phase changes", func() {
+		Expect(validatePhaseTransition("", PhaseDualWrite)).To(Succeed())
+		Expect(validatePhaseTransition(PhaseOff, PhaseDualWrite)).To(Succeed())
+		Expect(validatePhaseTransition(PhaseDualWrite, PhaseShadowRead)).To(Succeed())
+		Expect(validatePhaseTransition(PhaseShadowRead, PhaseReadFromNew)).To(Succeed())
+		Expect(validatePhaseTransition(PhaseReadFromNew, PhaseCutover)).To(Succeed())
+		Expect(validatePhaseTransition(PhaseCutover, PhaseRollback)).To(Succeed())
+		Expect(validatePhaseTransition(PhaseRollback, PhaseOff)).To(Succeed())
+		Expect(validatePhaseTransition(PhaseShadowRead, PhaseShadowRead)).To(Succeed())
+		Expect(validatePhaseTransition(PhaseOff, PhaseReadThrough)).To(Succeed())
+		Expect(validatePhaseTransition(PhaseReadThrough, PhaseCutover)).To(Succeed())
+		Expect(validatePhaseTransition(PhaseReadThrough, PhaseRollback)).To(Succeed())
+	})
+
+	It("rejects the illegal phase changes", func() {
+		Expect(validatePhaseTransition(PhaseOff, PhaseShadowRead)).NotTo(Succeed())
+		Expect(validatePhaseTransition("", PhaseReadFromNew)).NotTo(Succeed())
+		Expect(validatePhaseTransition(PhaseDualWrite, PhaseCutover)).NotTo(Succeed())
+		Expect(validatePhaseTransition(PhaseCutover, PhaseDualWrite)).NotTo(Succeed())
+		Expect(validatePhaseTransition(PhaseCutover, PhaseOff)).NotTo(Succeed())
+		Expect(validatePhaseTransition(PhaseDualWrite, PhaseReadThrough)).NotTo(Succeed())
+		Expect(validatePhaseTransition(PhaseReadThrough, PhaseOff)).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("Test MigrationPhase", func() {
+	var configurer *fakeConfigurer
+	var client *connectorImpl
+
+	BeforeEach(func() {
+		config := clusterConfig()
+		config.MigrationPhase = PhaseDualWrite
+		config.ProcessAllLoadTestPackets = true
+		configurer = &fakeConfigurer{config: config}
+		c, err := NewDynamicConnector(context.Background(), configurer)
+		Expect(err).NotTo(HaveOccurred())
+		client = c.(*connectorImpl)
+		_, err = client.mainClient().Do(context.Background(), "flushall")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.loadRouting().loadTestClients[0].Do(context.Background(), "flushall")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		client.ShutDown(context.Background())
+	})
+
+	It("rejects skipping a phase in reloading", func() {
+		configurer.config.MigrationPhase = PhaseCutover
+		Expect(configurer.callback()).NotTo(Succeed())
+		Expect(client.loadRouting().phase).To(Equal(PhaseDualWrite))
+	})
+
+	It("routes the traffic by phase", func() {
+		_, err := client.Do(context.Background(), "set", "foo", "bar")
+		Expect(err).NotTo(HaveOccurred())
+		time.Sleep(100 * time.Millisecond) // wait for the packet to be processed
+		get, _ := client.loadRouting().loadTestClients[0].Do(context.Background(), "get", "foo")
+		Expect(get).To(Equal("bar"))
+
+		configurer.config.MigrationPhase = PhaseShadowRead
+		Expect(configurer.callback()).To(Succeed())
+		configurer.config.MigrationPhase = PhaseReadFromNew
+		Expect(configurer.callback()).To(Succeed())
+
+		// the reads are served by the new cluster
+		_, err = client.loadRouting().loadTestClients[0].Do(context.Background(), "set", "foo", "new")
+		Expect(err).NotTo(HaveOccurred())
+		get, _ = client.Do(context.Background(), "get", "foo")
+		Expect(get).To(Equal("new"))
+		get, _ = client.Do(context.Background(), "GET", "foo")
+		Expect(get).To(Equal("new"))
+
+		configurer.config.MigrationPhase = PhaseCutover
+		Expect(configurer.callback()).To(Succeed())
+
+		// the writes are served by the new cluster and written back to the old cluster
+		_, err = client.Do(context.Background(), "set", "foo", "cutover")
+		Expect(err).NotTo(HaveOccurred())
+		get, _ = client.loadRouting().loadTestClients[0].Do(context.Background(), "get", "foo")
+		Expect(get).To(Equal("cutover"))
+		time.Sleep(100 * time.Millisecond)
+		get, _ = client.mainClient().Do(context.Background(), "get", "foo")
+		Expect(get).To(Equal("cutover"))
+
+		configurer.config.MigrationPhase = PhaseRollback
+		Expect(configurer.callback()).To(Succeed())
+		get, _ = client.Do(context.Background(), "get", "foo")
+		Expect(get).To(Equal("cutover"))
+	})
+})
+
+var _ = Describe("Test ReadThrough", func() {
+	var client *connectorImpl
+
+	BeforeEach(func() {
+		config := clusterConfig()
+		config.MigrationPhase = PhaseReadThrough
+		c, err := NewStaticConnector(context.Background(), config)
+		Expect(err).NotTo(HaveOccurred())
+		client = c.(*connectorImpl)
+		_, err = client.mainClient().Do(context.Background(), "flushall")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.loadRouting().loadTestClients[0].Do(context.Background(), "flushall")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		client.ShutDown(context.Background())
+	})
+
+	It("reads the missed keys from the old cluster and copies them with the TTL", func() {
+		_, err := client.mainClient().Do(context.Background(), "set", "foo", "old", "px", 100000)
+		Expect(err).NotTo(HaveOccurred())
+
+		get, err := client.Do(context.Background(), "get", "foo")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(get).To(Equal("old"))
+		Eventually(func() interface{} {
+			get, _ := client.loadRouting().loadTestClients[0].Do(context.Background(), "get", "foo")
+			return get
+		}).Should(Equal("old"))
+		ttl, _ := client.loadRouting().loadTestClients[0].Do(context.Background(), "pttl", "foo")
+		Expect(ttl).To(BeNumerically(">", 0))
+		Expect(ttl).To(BeNumerically("<=", 100000))
+
+		get, err = client.Do(context.Background(), "get", "missing")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(get).To(BeNil())
+	})
+
+	It("reads the nil replies of a read-only pipeline from the old cluster", func() {
+		_, err := client.mainClient().Do(context.Background(), "set", "foo", "old")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.loadRouting().loadTestClients[0].Do(context.Background(), "set", "bar", "new")
+		Expect(err).NotTo(HaveOccurred())
+
+		replies, err := client.Pipeline(context.Background(), [][]interface{}{{"get", "foo"}, {"get", "bar"}, {"get", "missing"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(replies[0].Value).To(Equal("old"))
+		Expect(replies[1].Value).To(Equal("new"))
+		Expect(replies[2].Value).To(BeNil())
+		Eventually(func() interface{} {
+			get, _ := client.loadRouting().loadTestClients[0].Do(context.Background(), "get", "foo")
+			return get
+		}).Should(Equal("old"))
+	})
+
+	It("writes to the new cluster only and deletes from both", func() {
+		_, err := client.Do(context.Background(), "set", "foo", "new")
+		Expect(err).NotTo(HaveOccurred())
+		get, _ := client.loadRouting().loadTestClients[0].Do(context.Background(), "get", "foo")
+		Expect(get).To(Equal("new"))
+		get, _ = client.mainClient().Do(context.Background(), "get", "foo")
+		Expect(get).To(BeNil())
+
+		_, err = client.mainClient().Do(context.Background(), "set", "bar", "old")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.Do(context.Background(), "del", "bar")
+		Expect(err).NotTo(HaveOccurred())
+		get, _ = client.mainClient().Do(context.Background(), "get", "bar")
+		Expect(get).To(BeNil())
+		get, _ = client.Do(context.Background(), "get", "bar")
+		Expect(get).To(BeNil())
+	})
+
+	It("doesn't read through a missing field of a key in the new cluster", func() {
+		_, err := client.mainClient().Do(context.Background(), "hset", "foo", "field", "old")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.loadRouting().loadTestClients[0].Do(context.Background(), "hset", "foo", "other", "new")
+		Expect(err).NotTo(HaveOccurred())
+		get, err := client.Do(context.Background(), "hget", "foo", "field")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(get).To(BeNil())
+
+		_, err = client.mainClient().Do(context.Background(), "hset", "bar", "field", "old")
+		Expect(err).NotTo(HaveOccurred())
+		replies, err := client.Pipeline(context.Background(), [][]interface{}{{"hget", "foo", "field"}, {"hget", "bar", "field"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(replies[0].Value).To(BeNil())
+		Expect(replies[1].Value).To(Equal("old"))
+	})
+
+	It("deletes the written keys from the old cluster", func() {
+		for _, key := range []string{"getdel", "hdel", "expired", "counter"} {
+			_, err := client.mainClient().Do(context.Background(), "set", key, "5")
+			Expect(err).NotTo(HaveOccurred())
+		}
+		_, err := client.mainClient().Do(context.Background(), "hset", "hash", "field", "old")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = client.Do(context.Background(), "getdel", "getdel")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.Do(context.Background(), "hdel", "hash", "field")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.Do(context.Background(), "set", "expired", "new", "px", 50)
+		Expect(err).NotTo(HaveOccurred())
+		// the write to a key only in the old cluster starts from an empty key
+		incr, err := client.Do(context.Background(), "incr", "counter")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(incr).To(Equal(int64(1)))
+
+		time.Sleep(100 * time.Millisecond)
+		for _, key := range []string{"getdel", "expired"} {
+			get, err := client.Do(context.Background(), "get", key)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(get).To(BeNil())
+		}
+		get, err := client.Do(context.Background(), "hget", "hash", "field")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(get).To(BeNil())
+		get, _ = client.mainClient().Do(context.Background(), "get", "counter")
+		Expect(get).To(BeNil())
+	})
+
+	It("deletes the keys of the scripts from the old cluster", func() {
+		_, err := client.mainClient().Do(context.Background(), "set", "foo", "old")
+		Expect(err).NotTo(HaveOccurred())
+		script := redisapi.NewScript(1, "return redis.call('DEL', KEYS[1])")
+		_, err = client.Run(context.Background(), script, "foo")
+		Expect(err).NotTo(HaveOccurred())
+
+		get, err := client.Do(context.Background(), "get", "foo")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(get).To(BeNil())
+	})
+})
+
+var _ = Describe("Test MigrationPhase routing", func() {
+	config := func(phase MigrationPhase) *ConnectorConfig {
+		return &ConnectorConfig{
+			Main:           &ClientConfig{Addrs: []string{"old:6379"}, ClientMode: ModeSingleHost},
+			LoadTests:      []*ClientConfig{{Addrs: []string{"new:6379"}, ClientMode: ModeSingleHost}},
+			MigrationPhase: phase,
+		}
+	}
+
+	It("rejects the reads from a cluster the mirrored writes may be dropped to", func() {
+		c := config(PhaseReadFromNew)
+		Expect(c.initAndValidate()).NotTo(Succeed())
+
+		c = config(PhaseReadFromNew)
+		c.LoadTests[0].SyncWrite = true
+		Expect(c.initAndValidate()).To(Succeed())
+
+		c = config(PhaseReadFromNew)
+		c.ProcessAllLoadTestPackets = true
+		Expect(c.initAndValidate()).To(Succeed())
+
+		c = config(PhaseCutover)
+		Expect(c.initAndValidate()).To(Succeed())
+	})
+
+	It("rejects the reads from a sampled cluster", func() {
+		c := config(PhaseReadFromNew)
+		c.LoadTests[0].SyncWrite = true
+		c.LoadTests[0].SampleRate = 0.5
+		Expect(c.initAndValidate()).NotTo(Succeed())
+
+		c = config(PhaseDualWrite)
+		c.LoadTests[0].SampleRate = 0.5
+		Expect(c.initAndValidate()).To(Succeed())
+
+		c = config(PhaseCutover)
+		c.LoadTests[0].SampleRate = 1
+		Expect(c.initAndValidate()).To(Succeed())
+	})
+
+	It("rejects the reads from a rate limited cluster", func() {
+		c := config(PhaseReadThrough)
+		c.LoadTests[0].MaxOpsPerSecond = 100
+		Expect(c.initAndValidate()).NotTo(Succeed())
+	})
+
+	It("rejects the reads from a cluster filtered by the mirror rules", func() {
+		c := config(PhaseCutover)
+		c.MirrorRules = []*MirrorRule{{Action: MirrorAllow, KeyPattern: "user:*"}}
+		Expect(c.initAndValidate()).NotTo(Succeed())
+
+		c = config(PhaseCutover)
+		c.MirrorRules = []*MirrorRule{{Action: MirrorDeny, Commands: []string{"DEL"}}}
+		Expect(c.initAndValidate()).NotTo(Succeed())
+
+		// the rules of the other load test clients don't matter
+		c = config(PhaseCutover)
+		c.MirrorRules = []*MirrorRule{{Action: MirrorDeny, LoadTests: []string{"other:6379"}}}
+		Expect(c.initAndValidate()).To(Succeed())
+
+		c = config(PhaseCutover)
+		c.MirrorRules = []*MirrorRule{{Action: MirrorAllow}}
+		Expect(c.initAndValidate()).To(Succeed())
+	})
+})
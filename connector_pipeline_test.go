@@ -28,23 +28,72 @@ package redis
 
 import (
 	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/grab/grab-redis/redisapi"
+	goredis "github.com/grab/redis/v8"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
+// resetCmdStats resets the cmd stats of every master of the client
+func resetCmdStats(client redisapi.Client) {
+	err := client.(*connectorImpl).mainClient().wrappedClient.forEachMaster(context.Background(), func(ctx context.Context, node *goredis.Client) error {
+		return node.Do(ctx, "CONFIG", "RESETSTAT").Err()
+	})
+	Expect(err).NotTo(HaveOccurred())
+}
+
+// cmdCalls sums the calls of the cmds starting with the prefix on every master of the client since the cmd stats are reset
+func cmdCalls(client redisapi.Client, prefix string) int {
+	var mu sync.Mutex
+	calls := 0
+	err := client.(*connectorImpl).mainClient().wrappedClient.forEachMaster(context.Background(), func(ctx context.Context, node *goredis.Client) error {
+		info, err := node.Do(ctx, "INFO", "commandstats").Text()
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(info, "\n") {
+			// cmdstat_get:calls=1,usec=2,usec_per_call=2.00
+			if !strings.HasPrefix(line, "cmdstat_"+prefix) {
+				continue
+			}
+			fields := strings.SplitN(strings.SplitN(line, ":", 2)[1], ",", 2)
+			n, err := strconv.Atoi(strings.TrimPrefix(fields[0], "calls="))
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			calls += n
+			mu.Unlock()
+		}
+		return nil
+	})
+	Expect(err).NotTo(HaveOccurred())
+	return calls
+}
+
 var _ = Describe("pipelining in CLUSTER MODE", func() {
 	var client redisapi.Client
+	var validate redisapi.Client
 
 	BeforeEach(func() {
 		config := clusterConfig()
 		client, _ = NewStaticConnector(context.Background(), config)
 		_, err := client.Do(context.Background(), "flushall")
 		Expect(err).NotTo(HaveOccurred())
+
+		validate, _ = NewStaticConnector(context.Background(), loadTestValidation())
+		_, err = validate.Do(context.Background(), "flushall")
+		Expect(err).NotTo(HaveOccurred())
 	})
 
 	AfterEach(func() {
 		client.ShutDown(context.Background())
+		validate.ShutDown(context.Background())
 	})
 
 	It("supports Pipeline interface", func() {
@@ -72,6 +121,35 @@ var _ = Describe("pipelining in CLUSTER MODE", func() {
 		Expect(cmds[1].Value).To(Equal("Bad"))
 		Expect(cmds[2].Value).To(Equal("PONG"))
 	})
+	It("routes the read-only pipeline with IgnoreReadOnly", func() {
+		_, err := client.Do(context.Background(), "SET", "Apple", "Good")
+		Expect(err).NotTo(HaveOccurred())
+
+		time.Sleep(1 * time.Second) // wait for the SET to be mirrored
+		resetCmdStats(validate)
+
+		cmds, err := client.Pipeline(context.Background(), [][]interface{}{{"GET", "Apple"}, {"EXISTS", "Apple"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cmds[0].Value).To(Equal("Good"))
+		Expect(cmds[1].Value).To(Equal(int64(1)))
+
+		time.Sleep(1 * time.Second) // wait for the packet to be processed
+		Expect(cmdCalls(validate, "get")).To(BeZero())
+		Expect(cmdCalls(validate, "exists")).To(BeZero())
+	})
+
+	It("only mirrors the writes of a mixed pipeline with IgnoreReadOnly", func() {
+		resetCmdStats(validate)
+
+		cmds, err := client.Pipeline(context.Background(), [][]interface{}{{"SET", "Apple", "Good"}, {"GET", "Apple"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cmds[1].Value).To(Equal("Good"))
+
+		time.Sleep(1 * time.Second) // wait for the packet to be processed
+		Expect(cmdCalls(validate, "set")).To(Equal(1))
+		Expect(cmdCalls(validate, "get")).To(BeZero())
+		Expect(validate.Do(context.Background(), "GET", "Apple")).To(Equal("Good"))
+	})
 })
 
 var _ = Describe("pipelining in NON CLUSTER MODE", func() {
@@ -114,3 +192,43 @@ var _ = Describe("pipelining in NON CLUSTER MODE", func() {
 		Expect(cmds[2].Value).To(Equal("PONG"))
 	})
 })
+
+var _ = Describe("pipelining with IgnoreReadOnly", func() {
+	It("keeps only the write cmds", func() {
+		client := &clientImpl{
+			cmdCache: map[string]*goredis.CommandInfo{
+				"get": {Name: "get", ReadOnly: true},
+				"set": {Name: "set"},
+			},
+		}
+		writes := client.writeCmds([][]interface{}{
+			{"GET", "a"},
+			{"SET", "a", "1"},
+			{"get", "b"},
+			{"UNKNOWN", "c"},
+		})
+		Expect(writes).To(Equal([][]interface{}{{"SET", "a", "1"}, {"UNKNOWN", "c"}}))
+		Expect(client.writeCmds([][]interface{}{{"GET", "a"}})).To(BeEmpty())
+	})
+})
+
+var _ = Describe("pipelining with an empty argsList", func() {
+	It("doesn't mirror the pipeline", func() {
+		loadTest := &clientImpl{config: &ClientConfig{Addrs: []string{"load-test:6379"}, ClientMode: ModeSingleHost}}
+		c := &connectorImpl{
+			loadTestScheduler: newScheduler(&schedulerOptions{maxChanSize: 10, maxWorker: 1, orderedByKey: true}),
+			stats:             NewNoopStatsClient(),
+			logger:            NewNoopLogger(),
+		}
+		r := &routing{client: &clientImpl{config: &ClientConfig{}}, loadTestClients: []*clientImpl{loadTest}}
+		c.routing.Store(r)
+
+		key, hasKey := newPipelineRequest(nil).firstKey(r.client)
+		Expect(key).To(BeEmpty())
+		Expect(hasKey).To(BeFalse())
+
+		c.queueLoadTest(r, newPipelineRequest(nil))
+		c.queueLoadTest(r, newPipelineRequest([][]interface{}{}))
+		Expect(c.loadTestScheduler.backlog()).To(BeZero())
+	})
+})
@@ -28,6 +28,8 @@ package redis
 
 import (
 	"context"
+	"time"
+
 	"github.com/grab/grab-redis/redisapi"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -35,16 +37,22 @@ import (
 
 var _ = Describe("Run CLUSTER ON", func() {
 	var client redisapi.Client
+	var validate redisapi.Client
 
 	BeforeEach(func() {
 		config := clusterConfig()
 		client, _ = NewStaticConnector(context.Background(), config)
 		_, err := client.Do(context.Background(), "flushall")
 		Expect(err).NotTo(HaveOccurred())
+
+		validate, _ = NewStaticConnector(context.Background(), loadTestValidation())
+		_, err = validate.Do(context.Background(), "flushall")
+		Expect(err).NotTo(HaveOccurred())
 	})
 
 	AfterEach(func() {
 		client.ShutDown(context.Background())
+		validate.ShutDown(context.Background())
 	})
 
 	It("test eval", func() {
@@ -55,6 +63,21 @@ var _ = Describe("Run CLUSTER ON", func() {
 		run, _ = client.RunReadOnly(context.Background(), script2, "hello", "hello", "world")
 		Expect(run).To(Equal("world"))
 	})
+	It("runs the read-only script with IgnoreReadOnly", func() {
+		script := redisapi.NewReadOnlyScript(1, "return redis.call('GET', KEYS[1])")
+		Expect(script.ReadOnly()).To(BeTrue())
+		_, err := client.Do(context.Background(), "SET", "key", "value")
+		Expect(err).NotTo(HaveOccurred())
+		resetCmdStats(validate)
+
+		run, err := client.Run(context.Background(), script, "key")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(run).To(Equal("value"))
+
+		time.Sleep(1 * time.Second) // wait for the packet to be processed
+		Expect(cmdCalls(validate, "eval")).To(BeZero())
+		Expect(cmdCalls(validate, "script")).To(BeZero())
+	})
 })
 
 var _ = Describe("Run CLUSTER OFF", func() {
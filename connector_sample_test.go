@@ -0,0 +1,113 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"fmt"
+
+	goredis "github.com/grab/redis/v8"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/grab/grab-redis/redisapi"
+)
+
+var _ = Describe("Test Sampling", func() {
+	var c *connectorImpl
+	var r *routing
+	var loadTest *clientImpl
+
+	BeforeEach(func() {
+		r = &routing{
+			client: &clientImpl{
+				cmdCache: map[string]*goredis.CommandInfo{
+					"set":  {Name: "set", FirstKeyPos: 1},
+					"ping": {Name: "ping"},
+				},
+			},
+		}
+		c = &connectorImpl{}
+		c.routing.Store(r)
+		loadTest = &clientImpl{config: &ClientConfig{SampleRate: 0.5, SampleMode: SampleByKeyHash}}
+	})
+
+	It("sends everything when the sample rate is 0", func() {
+		loadTest.config.SampleRate = 0
+		req := newDoRequest("SET", []interface{}{"k", "v"})
+		Expect(c.requestFor(r, loadTest, req)).To(Equal(req))
+	})
+
+	It("samples the same keys by key hash", func() {
+		sampled := 0
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			req := newDoRequest("SET", []interface{}{key, "v"})
+			first := c.requestFor(r, loadTest, req) != nil
+			Expect(c.requestFor(r, loadTest, req) != nil).To(Equal(first))
+			if first {
+				sampled++
+			}
+		}
+		Expect(sampled).To(BeNumerically("~", 500, 100))
+	})
+
+	It("samples the keys with the same hash tag together", func() {
+		for i := 0; i < 100; i++ {
+			tag := fmt.Sprintf("{user-%d}", i)
+			Expect(isKeySampled(tag+":a", 0.5)).To(Equal(isKeySampled(tag+":b", 0.5)))
+		}
+	})
+
+	It("always sends the cmds without key", func() {
+		loadTest.config.SampleRate = 0.000001
+		req := newDoRequest("PING", nil)
+		Expect(c.requestFor(r, loadTest, req)).To(Equal(req))
+	})
+
+	It("filters the cmds of a pipeline by their own keys", func() {
+		var argsList [][]interface{}
+		expected := 0
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			argsList = append(argsList, []interface{}{"SET", key, "v"})
+			if isKeySampled(key, 0.5) {
+				expected++
+			}
+		}
+		argsList = append(argsList, []interface{}{"PING"})
+
+		sampled := c.requestFor(r, loadTest, newPipelineRequest(argsList))
+		Expect(sampled).NotTo(BeNil())
+		Expect(sampled.cmds).To(HaveLen(expected + 1))
+	})
+
+	It("samples scripts by the first key", func() {
+		script := redisapi.NewScript(1, "return 1")
+		req := newRunRequest(script, []interface{}{"key-1", "arg"})
+		Expect(c.requestFor(r, loadTest, req) != nil).To(Equal(isKeySampled("key-1", 0.5)))
+	})
+})
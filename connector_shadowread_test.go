@@ -0,0 +1,179 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeStatsClient records the number of times each metric is counted, and the last value of each gauge
+type fakeStatsClient struct {
+	NoopStatsClient
+	mu     sync.Mutex
+	counts map[string]int
+	gauges map[string]float64
+}
+
+func newFakeStatsClient() *fakeStatsClient {
+	return &fakeStatsClient{counts: make(map[string]int), gauges: make(map[string]float64)}
+}
+
+func (f *fakeStatsClient) Gauge(name string, metric string, value float64, tags []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gauges[name+"."+metric] = value
+}
+
+func (f *fakeStatsClient) Duration(name string, elapsed string, now time.Time, tags ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.counts[elapsed+"|"+strings.Join(tags, ",")]++
+}
+
+func (f *fakeStatsClient) gauge(name string) (float64, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, ok := f.gauges[name]
+	return value, ok
+}
+
+func (f *fakeStatsClient) Count1(pkgName string, metric string, tags ...[]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, t := range tags {
+		f.counts[metric+"|"+strings.Join(t, ",")]++
+	}
+}
+
+func (f *fakeStatsClient) count(metric string, tag string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var total int
+	for key, count := range f.counts {
+		if strings.HasPrefix(key, metric+"|") && strings.Contains(key, tag) {
+			total += count
+		}
+	}
+	return total
+}
+
+var _ = Describe("Test ShadowRead", func() {
+	var stats *fakeStatsClient
+	var client *connectorImpl
+
+	BeforeEach(func() {
+		stats = newFakeStatsClient()
+		config := clusterConfig()
+		config.ShadowRead = true
+		c, err := NewStaticConnector(context.Background(), config, ConnectorStatsD(stats))
+		Expect(err).NotTo(HaveOccurred())
+		client = c.(*connectorImpl)
+		_, err = client.Do(context.Background(), "flushall")
+		Expect(err).NotTo(HaveOccurred())
+		time.Sleep(100 * time.Millisecond) // wait for the flushall to be processed by the load test client
+	})
+
+	AfterEach(func() {
+		client.ShutDown(context.Background())
+	})
+
+	It("reports match when both clusters have the same value", func() {
+		_, err := client.Do(context.Background(), "set", "foo", "bar")
+		Expect(err).NotTo(HaveOccurred())
+		time.Sleep(100 * time.Millisecond)
+
+		get, err := client.Do(context.Background(), "get", "foo")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(get).To(Equal("bar"))
+		Eventually(func() int { return stats.count(metricMatch, tagCmdPrefix+"get") }).Should(Equal(1))
+		Expect(stats.count(metricMismatch, tagCmdPrefix+"get")).To(Equal(0))
+	})
+
+	It("reports mismatch when the load test cluster is behind", func() {
+		_, err := client.mainClient().Do(context.Background(), "set", "foo", "bar")
+		Expect(err).NotTo(HaveOccurred())
+
+		get, err := client.Do(context.Background(), "get", "foo")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(get).To(Equal("bar"))
+		Eventually(func() int { return stats.count(metricMismatch, tagCmdPrefix+"get") }).Should(Equal(1))
+	})
+
+	It("shadow reads the uppercase cmds", func() {
+		_, err := client.Do(context.Background(), "SET", "foo", "bar")
+		Expect(err).NotTo(HaveOccurred())
+		time.Sleep(100 * time.Millisecond)
+
+		get, err := client.DoReadOnly(context.Background(), "GET", "foo")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(get).To(Equal("bar"))
+		Eventually(func() int { return stats.count(metricMatch, tagCmdPrefix+"get") }).Should(Equal(1))
+	})
+})
+
+var _ = Describe("isReplyEqual", func() {
+	It("compares replies", func() {
+		Expect(isReplyEqual("a", "a", false)).To(BeTrue())
+		Expect(isReplyEqual(nil, "a", false)).To(BeFalse())
+		Expect(isReplyEqual(int64(1), int64(1), false)).To(BeTrue())
+		Expect(isReplyEqual([]interface{}{"a", "b"}, []interface{}{"b", "a"}, false)).To(BeFalse())
+		Expect(isReplyEqual([]interface{}{"a", "b"}, []interface{}{"b", "a"}, true)).To(BeTrue())
+		Expect(isReplyEqual([]interface{}{"a", "b"}, []interface{}{"a", "c"}, true)).To(BeFalse())
+	})
+})
+
+var _ = Describe("ShadowReadLogSampleRate", func() {
+	config := func() *ConnectorConfig {
+		return &ConnectorConfig{
+			Main:       &ClientConfig{Addrs: []string{"old:6379"}, ClientMode: ModeSingleHost},
+			ShadowRead: true,
+		}
+	}
+
+	It("defaults only if unset", func() {
+		c := config()
+		Expect(c.initAndValidate()).To(Succeed())
+		Expect(*c.ShadowReadLogSampleRate).To(Equal(defaultShadowReadLogSampleRate))
+
+		c = config()
+		rate := 0.0
+		c.ShadowReadLogSampleRate = &rate
+		Expect(c.initAndValidate()).To(Succeed())
+		Expect(*c.ShadowReadLogSampleRate).To(Equal(0.0))
+
+		c = config()
+		rate = 2
+		c.ShadowReadLogSampleRate = &rate
+		Expect(c.initAndValidate()).NotTo(Succeed())
+	})
+})
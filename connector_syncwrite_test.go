@@ -0,0 +1,195 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/grab/grab-redis/redisapi"
+	goredis "github.com/grab/redis/v8"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test SyncWrite LoadTests", func() {
+	var client redisapi.Client
+	var validate redisapi.Client
+
+	BeforeEach(func() {
+		config := clusterConfig()
+		config.LoadTests[0].SyncWrite = true
+		client, _ = NewStaticConnector(context.Background(), config)
+		_, err := client.Do(context.Background(), "flushall")
+		Expect(err).NotTo(HaveOccurred())
+
+		configValidate := loadTestValidation()
+		validate, _ = NewStaticConnector(context.Background(), configValidate)
+		_, err = validate.Do(context.Background(), "flushall")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		client.ShutDown(context.Background())
+		validate.ShutDown(context.Background())
+	})
+
+	It("writes to the load test cluster before returning", func() {
+		set, err := client.Do(context.Background(), "set", "foo", "bar")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(set).To(Equal("OK"))
+		get, _ := validate.Do(context.Background(), "get", "foo")
+		Expect(get).To(Equal("bar"))
+	})
+
+	It("writes pipelines to the load test cluster before returning", func() {
+		_, err := client.Pipeline(context.Background(), [][]interface{}{{"set", "foo", "bar"}})
+		Expect(err).NotTo(HaveOccurred())
+		get, _ := validate.Do(context.Background(), "get", "foo")
+		Expect(get).To(Equal("bar"))
+	})
+
+	It("writes the succeeded cmds of a pipeline with an error reply", func() {
+		_, err := client.Do(context.Background(), "set", "text", "v")
+		Expect(err).NotTo(HaveOccurred())
+
+		replies, err := client.Pipeline(context.Background(), [][]interface{}{
+			{"set", "foo", "bar"},
+			{"incr", "text"},
+			{"set", "baz", "qux"},
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(replies[0].Err).NotTo(HaveOccurred())
+		Expect(replies[1].Err).To(HaveOccurred())
+		get, _ := validate.Do(context.Background(), "get", "foo")
+		Expect(get).To(Equal("bar"))
+		get, _ = validate.Do(context.Background(), "get", "baz")
+		Expect(get).To(Equal("qux"))
+	})
+
+	It("publishes to the load test cluster before returning", func() {
+		pubsub, err := validate.Subscribe(context.Background(), 1, "mychannel")
+		Expect(err).NotTo(HaveOccurred())
+		defer pubsub.Unsubscribe()
+		time.Sleep(100 * time.Millisecond) // wait for the subscription to be registered
+
+		_, err = client.Publish(context.Background(), "mychannel", "v")
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(pubsub.ResultChan).Should(Receive())
+	})
+})
+
+var _ = Describe("Test SyncWrite pipeline", func() {
+	var c *connectorImpl
+	var r *routing
+
+	BeforeEach(func() {
+		r = &routing{
+			client: &clientImpl{
+				config: &ClientConfig{},
+				cmdCache: map[string]*goredis.CommandInfo{
+					"get":  {Name: "get", ReadOnly: true},
+					"set":  {Name: "set"},
+					"incr": {Name: "incr"},
+				},
+			},
+		}
+		c = &connectorImpl{}
+		c.routing.Store(r)
+	})
+
+	argsList := [][]interface{}{{"SET", "a", "1"}, {"INCR", "b"}, {"GET", "a"}}
+
+	It("only sync writes the succeeded cmds", func() {
+		req := c.succeededRequest(r, argsList, []redisapi.ReplyPair{{Value: "OK"}, {Err: fmt.Errorf("ERR not an integer")}, {Value: "1"}})
+		Expect(req.cmds).To(Equal([][]interface{}{{"SET", "a", "1"}, {"GET", "a"}}))
+
+		r.client.config.IgnoreReadOnly = true
+		req = c.succeededRequest(r, argsList, []redisapi.ReplyPair{{Value: "OK"}, {Err: fmt.Errorf("ERR not an integer")}, {Value: "1"}})
+		Expect(req.cmds).To(Equal([][]interface{}{{"SET", "a", "1"}}))
+	})
+
+	It("skips the sync write without a succeeded write", func() {
+		Expect(c.succeededRequest(r, argsList, nil)).To(BeNil())
+		err := fmt.Errorf("connection refused")
+		Expect(c.succeededRequest(r, argsList, []redisapi.ReplyPair{{Err: err}, {Err: err}, {Err: err}})).To(BeNil())
+		Expect(c.succeededRequest(r, argsList, []redisapi.ReplyPair{{Err: err}, {Err: err}, {Value: "1"}})).To(BeNil())
+	})
+})
+
+var _ = Describe("Test SyncWrite policy", func() {
+	It("fails the call by default when the load test client write fails", func() {
+		config := clusterConfig()
+		config.LoadTests[0].SyncWrite = true
+		client, _ := NewStaticConnector(context.Background(), config)
+		defer client.ShutDown(context.Background())
+
+		_, err := client.Do(context.Background(), "del", "list")
+		Expect(err).NotTo(HaveOccurred())
+
+		// the load test client fails with WRONGTYPE while the main client succeeds
+		_, _ = client.(*connectorImpl).loadRouting().loadTestClients[0].Do(context.Background(), "set", "list", "v")
+		_, err = client.Do(context.Background(), "lpush", "list", "v")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("only logs the failure with SyncWriteLog", func() {
+		config := clusterConfig()
+		config.LoadTests[0].SyncWrite = true
+		config.LoadTests[0].SyncWritePolicy = SyncWriteLog
+		client, _ := NewStaticConnector(context.Background(), config)
+		defer client.ShutDown(context.Background())
+
+		_, err := client.Do(context.Background(), "del", "list")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, _ = client.(*connectorImpl).loadRouting().loadTestClients[0].Do(context.Background(), "set", "list", "v")
+		_, err = client.Do(context.Background(), "lpush", "list", "v")
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+var _ = Describe("SyncWriteMaxRetries", func() {
+	It("defaults only if unset", func() {
+		c := &ClientConfig{Addrs: []string{"new:6379"}, ClientMode: ModeSingleHost}
+		c.init()
+		Expect(c.validate()).To(Succeed())
+		Expect(*c.SyncWriteMaxRetries).To(Equal(defaultSyncWriteMaxRetries))
+
+		retries := 0
+		c = &ClientConfig{Addrs: []string{"new:6379"}, ClientMode: ModeSingleHost, SyncWriteMaxRetries: &retries}
+		c.init()
+		Expect(c.validate()).To(Succeed())
+		Expect(*c.SyncWriteMaxRetries).To(Equal(0))
+
+		retries = -1
+		c = &ClientConfig{Addrs: []string{"new:6379"}, ClientMode: ModeSingleHost, SyncWriteMaxRetries: &retries}
+		c.init()
+		Expect(c.validate()).NotTo(Succeed())
+	})
+})
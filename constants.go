@@ -32,21 +32,69 @@ const (
 	pkgName = "grabredis"
 
 	// redis commands
-	redisEval    = "EVAL"
-	redisEvalSha = "EVALSHA"
+	redisEval      = "EVAL"
+	redisEvalSha   = "EVALSHA"
+	redisPublish   = "PUBLISH"
+	redisSubscribe = "SUBSCRIBE"
+
+	// redis command flags
+	redisFlagRandom        = "random"
+	redisFlagSortForScript = "sort_for_script"
 
 	// redis err response checks
 	redisErrNoScript = "NOSCRIPT "
+	redisErrBusyKey  = "BUSYKEY "
 
-	metricError   = "error"
-	metricElapsed = "elapsed"
+	metricError      = "error"
+	metricElapsed    = "elapsed"
+	metricMatch      = "match"
+	metricMismatch   = "mismatch"
+	metricScanned    = "scanned"
+	metricCopied     = "copied"
+	metricSkipped    = "skipped"
+	metricFailed     = "failed"
+	metricChecked    = "checked"
+	metricSpooled    = "spooled"
+	metricReplayed   = "replayed"
+	metricDropped    = "dropped"
+	metricSize       = "size"
+	metricRetry      = "retry"
+	metricDeadLetter = "deadLetter"
+	metricBacklog    = "backlog"
+	metricCapacity   = "capacity"
+	metricLatency    = "latency"
+	metricLatencyP50 = "latency.p50"
+	metricLatencyP95 = "latency.p95"
+	metricLatencyP99 = "latency.p99"
+	metricLag        = "lag"
+	metricPanic      = "panic"
+	metricPromoted   = "promoted"
+	metricReplaced   = "replaced"
+	metricFallback   = "fallback"
+	metricHit        = "hit"
+	metricMiss       = "miss"
 
 	tagCmdPrefix             = "grab_redis_cmd:"
 	tagHostPrefix            = "grab_redis_host:"
+	tagFunctionPrefix        = "grab_redis_func:"
 	tagFunctionDo            = "grab_redis_func:do"
 	tagFunctionPipeline      = "grab_redis_func:pipeline"
 	tagFunctionRun           = "grab_redis_func:run"
+	tagFunctionPublish       = "grab_redis_func:publish"
+	tagFunctionSubscribe     = "grab_redis_func:subscribe"
 	tagFunctionQueueLoadTest = "grab_redis_func:queueLoadTest"
+	tagFunctionSyncLoadTest  = "grab_redis_func:syncLoadTest"
+	tagFunctionShadowRead    = "grab_redis_func:shadowRead"
+	tagFunctionSpool         = "grab_redis_func:spool"
+	tagFunctionMirror        = "grab_redis_func:mirror"
+	tagFunctionScheduler     = "grab_redis_func:scheduler"
+	tagFunctionRateLimit     = "grab_redis_func:rateLimit"
+	tagFunctionCapture       = "grab_redis_func:capture"
+	tagFunctionReplay        = "grab_redis_func:replay"
+	tagFunctionPromote       = "grab_redis_func:promote"
+	tagFunctionFallback      = "grab_redis_func:fallback"
+	tagFunctionReadThrough   = "grab_redis_func:readThrough"
+	tagReasonPrefix          = "grab_redis_reason:"
 	tagHystrixError          = "grab_redis_func:hystrix_error"
 	tagHystrixTimeout        = "grab_redis_func:hystrix_timeout"
 	tagHystrixCircuitOpen    = "grab_redis_func:hystrix_circuit_open"
@@ -78,4 +126,62 @@ const (
 	defaultMaxChanSize       = 10000
 	defaultMaxWorker         = 10
 	defaultWorkerIdleTimeout = 1000
+	laneHashMultiplier       = 0x9E3779B97F4A7C15
+	schedulerScaleInterval   = 100 * time.Millisecond
+	// schedulerSendRetryInterval is the interval to retry sending a task to a full channel with ProcessAllLoadTestPackets
+	schedulerSendRetryInterval = time.Millisecond
+
+	defaultSchedulerScaleCooldownInMs = 1000
+
+	// overflow spool
+	defaultSpoolMaxSizeInMB = 100
+	spoolFileName           = "grab-redis-load-test.spool"
+	spoolReplayInterval     = time.Second
+	spoolCompactBufferSize  = 32 * 1024
+
+	// promote
+	promoteDrainInterval  = 10 * time.Millisecond
+	defaultPromoteTimeout = 30 * time.Second
+
+	// key rewrite
+	defaultKeyRewriteHashTagPattern = "^([^:]+)"
+
+	// subscribe
+	subscribeDedupWindow = 10 * time.Second
+
+	// capture and replay
+	defaultCaptureMaxSizeInMB = 100
+	defaultCaptureMaxFiles    = 5
+	captureBufferSize         = 10000
+	captureFlushInterval      = time.Second
+	defaultReplaySpeed        = 1
+	defaultReplayConcurrency  = 100
+
+	// mirror retry
+	defaultMirrorRetryBackoffInMs    = 10
+	defaultMirrorRetryMaxBackoffInMs = 1000
+
+	// sampling
+	defaultSampleMode = SampleRandom
+	sampleHashBuckets = 10000
+
+	// shadow read
+	defaultShadowReadLogSampleRate = 0.01
+
+	// backfill
+	defaultBackfillMode             = BackfillAuto
+	defaultBackfillScanCount        = 100
+	defaultBackfillMaxKeysPerSecond = 1000
+	backfillChunkSize               = 1000
+	backfillTempKeySuffix           = ":backfill:tmp"
+	backfillCursorSaveInterval      = time.Second
+
+	// verify
+	defaultVerifyTTLToleranceInMs      = 1000
+	defaultVerifyMaxReportedMismatches = 100
+
+	// sync write
+	defaultSyncWritePolicy           = SyncWriteFail
+	defaultSyncWriteMaxRetries       = 3
+	defaultSyncWriteRetryBackoffInMs = 10
 )
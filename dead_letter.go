@@ -0,0 +1,236 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	goredis "github.com/grab/redis/v8"
+	"github.com/pkg/errors"
+
+	"github.com/grab/grab-redis/redisapi"
+)
+
+// DeadLetter is an async mirror write which still fails after the retries, it records the affected keys so the load test
+// cluster can be repaired, e.g. by the Backfiller.
+type DeadLetter struct {
+	// LoadTest is the name of the load test client
+	LoadTest string `json:"loadTest"`
+	// Function is one of do, pipeline, run and publish
+	Function string `json:"function"`
+	// Keys are the first keys of the failed cmds, or the keys of the script
+	Keys []string `json:"keys,omitempty"`
+	// Cmds are the failed cmds of Do, Pipeline and Publish
+	Cmds [][]string `json:"cmds,omitempty"`
+	// Script and KeysAndArgs are set for Run
+	Script      string    `json:"script,omitempty"`
+	KeysAndArgs []string  `json:"keysAndArgs,omitempty"`
+	Error       string    `json:"error"`
+	Time        time.Time `json:"time"`
+}
+
+// DeadLetterSink receives the async mirror writes which still fail after the retries.
+type DeadLetterSink interface {
+	// Write records the dead letter, it is called from the scheduler workers so it should not block for long.
+	Write(ctx context.Context, letter *DeadLetter) error
+}
+
+// FileDeadLetterSink appends the dead letters to a file, one JSON object per line.
+type FileDeadLetterSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileDeadLetterSink opens the file in append mode, the file is created if it doesn't exist.
+func NewFileDeadLetterSink(path string) (*FileDeadLetterSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileDeadLetterSink{file: file}, nil
+}
+
+// Write appends the dead letter to the file.
+func (s *FileDeadLetterSink) Write(ctx context.Context, letter *DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(data)
+	return err
+}
+
+// Close closes the file.
+func (s *FileDeadLetterSink) Close() error {
+	return s.file.Close()
+}
+
+// mirror sends the request to the load test client, the failed part of the request is retried with exponential backoff,
+// and sent to the dead letter sink if it still fails.
+func (c *connectorImpl) mirror(ctx context.Context, client *clientImpl, req *loadTestRequest) error {
+	failed, retryable, err := req.attempt(ctx, client)
+
+	config := client.config
+	backoff := parseDurationInMs(config.MirrorRetryBackoffInMs)
+	maxBackoff := parseDurationInMs(config.MirrorRetryMaxBackoffInMs)
+	for i := 0; i < config.MirrorMaxRetries && failed != nil && retryable; i++ {
+		c.stats.Count1(pkgName, metricRetry, client.getTags(tagFunctionMirror))
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			// the scheduler is shutting down, keep the failed part in the dead letters
+			c.deadLetter(ctx, client, failed, err)
+			return err
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		failed, retryable, err = failed.attempt(ctx, client)
+	}
+
+	if failed != nil {
+		c.deadLetter(ctx, client, failed, err)
+	}
+	return err
+}
+
+// attempt executes the request, it returns the failed part of the request and if it is worth retrying
+func (r *loadTestRequest) attempt(ctx context.Context, client *clientImpl) (*loadTestRequest, bool, error) {
+	if r.function != tagFunctionPipeline {
+		err := r.execute(ctx, client)
+		if err == nil {
+			return nil, false, nil
+		}
+		return r, isRetryable(err), err
+	}
+
+	// only the failed cmds of a pipeline are retried, the others might not be idempotent, e.g. INCR
+	replies, err := client.Pipeline(ctx, r.cmds)
+	if err == nil {
+		return nil, false, nil
+	}
+
+	failed, retryable := r.failedRequest(client, replies)
+	if failed == nil {
+		// the pipeline fails as a whole, e.g. the circuit is open
+		return r, isRetryable(err), err
+	}
+	return failed, retryable, err
+}
+
+// failedRequest returns the request of the failed cmds of a pipeline and if any of them is worth retrying, or nil if no
+// cmd fails. It's read-only if the failed cmds are all read-only, e.g. the failed reads of a pipeline mixing reads and writes.
+func (r *loadTestRequest) failedRequest(client *clientImpl, replies []redisapi.ReplyPair) (*loadTestRequest, bool) {
+	failed := *r
+	failed.cmds = nil
+	retryable := false
+	for i, reply := range replies {
+		if reply.Err == nil {
+			continue
+		}
+		failed.cmds = append(failed.cmds, r.cmds[i])
+		retryable = retryable || isRetryable(reply.Err)
+	}
+	if len(failed.cmds) == 0 {
+		return nil, false
+	}
+	failed.readonly = r.readonly || len(client.writeCmds(failed.cmds)) == 0
+	return &failed, retryable
+}
+
+// isRetryable returns false for the error replies of redis, e.g. WRONGTYPE, they fail again in the retries
+func isRetryable(err error) bool {
+	_, ok := errors.Cause(err).(goredis.Error)
+	return err != nil && !ok
+}
+
+func (c *connectorImpl) deadLetter(ctx context.Context, client *clientImpl, req *loadTestRequest, err error) {
+	if req.readonly {
+		return
+	}
+	c.stats.Count1(pkgName, metricDeadLetter, client.getTags(tagFunctionMirror))
+
+	letter := c.newDeadLetter(client, req, err)
+	if c.deadLetterSink == nil {
+		c.logger.Warn(pkgName, "mirror write to load test client %s failed, keys: %v, Error: %s", letter.LoadTest, letter.Keys, err)
+		return
+	}
+
+	if err := c.deadLetterSink.Write(ctx, letter); err != nil {
+		c.stats.Count1(pkgName, metricError, client.getTags(tagFunctionMirror))
+		c.logger.Error(pkgName, "failed to write dead letter, keys: %v, Error: %s", letter.Keys, err)
+	}
+}
+
+func (c *connectorImpl) newDeadLetter(client *clientImpl, req *loadTestRequest, err error) *DeadLetter {
+	letter := &DeadLetter{
+		LoadTest: client.config.name(),
+		Function: strings.TrimPrefix(req.function, tagFunctionPrefix),
+		Time:     time.Now(),
+	}
+	if err != nil {
+		letter.Error = err.Error()
+	}
+
+	for _, cmd := range req.cmds {
+		letter.Cmds = append(letter.Cmds, argsToStrings(cmd))
+		if key, ok := c.mainClient().firstKey(cmd); ok {
+			letter.Keys = append(letter.Keys, key)
+		}
+	}
+
+	if req.script != nil {
+		letter.Script = req.script.Source()
+		letter.KeysAndArgs = argsToStrings(req.keysAndArgs)
+		if keyCount := req.script.KeyCount(); keyCount > 0 && keyCount <= len(letter.KeysAndArgs) {
+			letter.Keys = letter.KeysAndArgs[:keyCount]
+		}
+	}
+
+	return letter
+}
+
+func argsToStrings(args []interface{}) []string {
+	result := make([]string, len(args))
+	for i, arg := range args {
+		result[i] = argToString(arg)
+	}
+	return result
+}
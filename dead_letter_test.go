@@ -0,0 +1,167 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	goredis "github.com/grab/redis/v8"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/grab/grab-redis/redisapi"
+)
+
+// fakeDeadLetterSink keeps the dead letters in memory
+type fakeDeadLetterSink struct {
+	mu      sync.Mutex
+	letters []*DeadLetter
+}
+
+func (f *fakeDeadLetterSink) Write(ctx context.Context, letter *DeadLetter) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.letters = append(f.letters, letter)
+	return nil
+}
+
+func (f *fakeDeadLetterSink) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for _, letter := range f.letters {
+		keys = append(keys, letter.Keys...)
+	}
+	return keys
+}
+
+var _ = Describe("Test DeadLetter", func() {
+	It("sends the failed mirror writes to the sink after the retries", func() {
+		stats := newFakeStatsClient()
+		sink := &fakeDeadLetterSink{}
+		config := clusterConfig()
+		// nothing listens on the port, the writes to the load test client always fail
+		config.LoadTests[0].Addrs = []string{"localhost:1"}
+		config.LoadTests[0].MirrorMaxRetries = 2
+		config.LoadTests[0].MirrorRetryBackoffInMs = 1
+		client, err := NewStaticConnector(context.Background(), config, ConnectorStatsD(stats), ConnectorDeadLetterSink(sink))
+		Expect(err).NotTo(HaveOccurred())
+		defer client.ShutDown(context.Background())
+
+		_, err = client.Do(context.Background(), "set", "foo", "bar")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.Do(context.Background(), "get", "foo")
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(sink.keys, 5).Should(Equal([]string{"foo"}))
+		Expect(stats.count(metricRetry, tagFunctionMirror)).To(Equal(2))
+	})
+
+	It("records the keys of the failed requests", func() {
+		c := &connectorImpl{}
+		c.routing.Store(&routing{
+			client: &clientImpl{
+				cmdCache: map[string]*goredis.CommandInfo{"set": {Name: "set", FirstKeyPos: 1}},
+			},
+		})
+		loadTest := &clientImpl{config: &ClientConfig{Addrs: []string{"load-test:6379"}, ClientMode: ModeSingleHost}}
+
+		letter := c.newDeadLetter(loadTest, newPipelineRequest([][]interface{}{{"SET", "a", 1}, {"SET", "b", 2}}), errors.New("failed"))
+		Expect(letter.LoadTest).To(Equal("load-test:6379"))
+		Expect(letter.Function).To(Equal("pipeline"))
+		Expect(letter.Keys).To(Equal([]string{"a", "b"}))
+		Expect(letter.Cmds).To(Equal([][]string{{"SET", "a", "1"}, {"SET", "b", "2"}}))
+		Expect(letter.Error).To(Equal("failed"))
+
+		letter = c.newDeadLetter(loadTest, newRunRequest(redisapi.NewScript(2, "return 1"), []interface{}{"a", "b", "c"}), nil)
+		Expect(letter.Keys).To(Equal([]string{"a", "b"}))
+		Expect(letter.Script).To(Equal("return 1"))
+	})
+
+	It("keeps the metadata of a pipeline in the failed cmds", func() {
+		loadTest := &clientImpl{
+			cmdCache: map[string]*goredis.CommandInfo{
+				"get": {Name: "get", ReadOnly: true},
+				"set": {Name: "set"},
+			},
+		}
+		req := newPipelineRequest([][]interface{}{{"SET", "a", 1}, {"GET", "b"}, {"SET", "c", 2}})
+		failedErr := errors.New("connection refused")
+
+		failed, retryable := req.failedRequest(loadTest, []redisapi.ReplyPair{{Value: "OK"}, {Err: failedErr}, {Err: failedErr}})
+		Expect(failed.function).To(Equal(tagFunctionPipeline))
+		Expect(failed.cmds).To(Equal([][]interface{}{{"GET", "b"}, {"SET", "c", 2}}))
+		Expect(failed.readonly).To(BeFalse())
+		Expect(retryable).To(BeTrue())
+
+		// only the reads of the mixed pipeline fail, they are not sent to the dead letter sink
+		failed, _ = req.failedRequest(loadTest, []redisapi.ReplyPair{{Value: "OK"}, {Err: failedErr}, {Value: "OK"}})
+		Expect(failed.cmds).To(Equal([][]interface{}{{"GET", "b"}}))
+		Expect(failed.readonly).To(BeTrue())
+
+		req.readonly = true
+		failed, retryable = req.failedRequest(loadTest, []redisapi.ReplyPair{{Err: goredis.Nil}, {Value: "b"}, {Value: "OK"}})
+		Expect(failed.readonly).To(BeTrue())
+		Expect(retryable).To(BeFalse())
+
+		failed, _ = req.failedRequest(loadTest, []redisapi.ReplyPair{{Value: "OK"}, {Value: "b"}, {Value: "OK"}})
+		Expect(failed).To(BeNil())
+	})
+
+	It("doesn't retry the error replies of redis", func() {
+		Expect(isRetryable(errors.New("connection refused"))).To(BeTrue())
+		Expect(isRetryable(goredis.Nil)).To(BeFalse())
+		Expect(isRetryable(nil)).To(BeFalse())
+	})
+
+	It("appends the dead letters to the file", func() {
+		dir, err := os.MkdirTemp("", "deadletter")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "dead.letter")
+		sink, err := NewFileDeadLetterSink(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sink.Write(context.Background(), &DeadLetter{LoadTest: "a", Keys: []string{"foo"}})).To(Succeed())
+		Expect(sink.Write(context.Background(), &DeadLetter{LoadTest: "b", Keys: []string{"bar"}})).To(Succeed())
+		Expect(sink.Close()).To(Succeed())
+
+		data, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+		Expect(lines).To(HaveLen(2))
+		letter := &DeadLetter{}
+		Expect(json.Unmarshal(lines[1], letter)).To(Succeed())
+		Expect(letter.Keys).To(Equal([]string{"bar"}))
+	})
+})
@@ -0,0 +1,77 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"context"
+	"net"
+
+	"github.com/myteksi/hystrix-go/hystrix"
+	"github.com/pkg/errors"
+)
+
+// isFallbackError returns whether the cmd failed by a circuit open or timeout error, which the fallback client serves.
+// The network and context timeouts are matched too, they are returned as is if the hystrix is disabled.
+func isFallbackError(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch errors.Cause(err) {
+	case hystrix.ErrCircuitOpen, hystrix.ErrTimeout, context.DeadlineExceeded:
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// fallbackClient returns the fallback client, it is the load test client of the same name if the fallback client isn't
+// created by the connector
+func (c *connectorImpl) fallbackClient(r *routing) *clientImpl {
+	if c.fallback != nil {
+		return c.fallback
+	}
+	for _, client := range r.loadTestClients {
+		if client.config.name() == c.fallbackName {
+			return client
+		}
+	}
+	return nil
+}
+
+// fallbackFor returns the client to retry the cmd failed on the client with err, or nil if the cmd doesn't fall back
+func (c *connectorImpl) fallbackFor(r *routing, client *clientImpl, err error, readonly bool) *clientImpl {
+	if c.fallbackName == "" || !isFallbackError(err) || (!readonly && !r.fallbackWrites) {
+		return nil
+	}
+	fallback := c.fallbackClient(r)
+	if fallback == nil || fallback == client {
+		return nil
+	}
+
+	c.stats.Count1(pkgName, metricFallback, fallback.getTags(tagFunctionFallback))
+	return fallback
+}
@@ -0,0 +1,127 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/myteksi/hystrix-go/hystrix"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("Test Fallback", func() {
+	var c *connectorImpl
+	var r *routing
+	var main, loadTest *clientImpl
+
+	BeforeEach(func() {
+		main = &clientImpl{config: &ClientConfig{Addrs: []string{"old:6379"}, ClientMode: ModeSingleHost}}
+		loadTest = &clientImpl{config: &ClientConfig{Addrs: []string{"new:6379"}, ClientMode: ModeSingleHost}}
+		r = &routing{client: main, loadTestClients: []*clientImpl{loadTest}}
+		c = &connectorImpl{
+			fallbackName: "new:6379",
+			stats:        NewNoopStatsClient(),
+		}
+	})
+
+	It("falls back on the circuit open and timeout errors", func() {
+		Expect(isFallbackError(hystrix.ErrCircuitOpen)).To(BeTrue())
+		Expect(isFallbackError(errors.Wrap(hystrix.ErrTimeout, "get"))).To(BeTrue())
+		Expect(isFallbackError(hystrix.ErrMaxConcurrency)).To(BeFalse())
+		Expect(isFallbackError(fmt.Errorf("ERR wrong number of arguments"))).To(BeFalse())
+		Expect(isFallbackError(nil)).To(BeFalse())
+	})
+
+	It("falls back on the network and context timeouts without the hystrix", func() {
+		Expect(isFallbackError(context.DeadlineExceeded)).To(BeTrue())
+		Expect(isFallbackError(errors.Wrap(context.DeadlineExceeded, "get"))).To(BeTrue())
+		Expect(isFallbackError(&net.OpError{Op: "read", Err: &net.DNSError{IsTimeout: true}})).To(BeTrue())
+		Expect(isFallbackError(&net.OpError{Op: "dial", Err: &net.DNSError{}})).To(BeFalse())
+		Expect(isFallbackError(context.Canceled)).To(BeFalse())
+	})
+
+	It("rejects the fallback changes in reloading", func() {
+		c.schedulerOptions = &schedulerOptions{}
+		c.routing.Store(r)
+		config := func(loadTests ...string) *ConnectorConfig {
+			config := &ConnectorConfig{
+				Main:     &ClientConfig{Addrs: []string{"old:6379"}, ClientMode: ModeSingleHost},
+				Fallback: &ClientConfig{Addrs: []string{"new:6379"}, ClientMode: ModeSingleHost},
+			}
+			for _, addr := range loadTests {
+				config.LoadTests = append(config.LoadTests, &ClientConfig{Addrs: []string{addr}, ClientMode: ModeSingleHost})
+			}
+			Expect(config.initAndValidate()).To(Succeed())
+			return config
+		}
+
+		Expect(c.validateReload(config("new:6379"))).To(Succeed())
+		// the load test client serving as the fallback client is removed
+		Expect(c.validateReload(config())).NotTo(Succeed())
+
+		removed := config("new:6379")
+		removed.Fallback = nil
+		Expect(c.validateReload(removed)).NotTo(Succeed())
+
+		changed := config("new:6379")
+		changed.Fallback.Addrs = []string{"replica:6379"}
+		Expect(c.validateReload(changed)).NotTo(Succeed())
+
+		// the fallback client created by the connector doesn't depend on the load test clients
+		c.fallback = &clientImpl{config: &ClientConfig{Addrs: []string{"new:6379"}, ClientMode: ModeSingleHost}}
+		Expect(c.validateReload(config())).To(Succeed())
+	})
+
+	It("uses the load test client of the fallback name", func() {
+		Expect(c.fallbackFor(r, main, hystrix.ErrCircuitOpen, true)).To(Equal(loadTest))
+		Expect(c.fallbackFor(r, main, hystrix.ErrMaxConcurrency, true)).To(BeNil())
+		Expect(c.fallbackFor(r, loadTest, hystrix.ErrCircuitOpen, true)).To(BeNil())
+
+		r.loadTestClients = nil
+		Expect(c.fallbackFor(r, main, hystrix.ErrCircuitOpen, true)).To(BeNil())
+
+		fallback := &clientImpl{config: &ClientConfig{Addrs: []string{"new:6379"}, ClientMode: ModeSingleHost}}
+		c.fallback = fallback
+		Expect(c.fallbackFor(r, main, hystrix.ErrCircuitOpen, true)).To(Equal(fallback))
+	})
+
+	It("falls back the writes only if FallbackWrites is enabled", func() {
+		Expect(c.fallbackFor(r, main, hystrix.ErrTimeout, false)).To(BeNil())
+
+		r.fallbackWrites = true
+		Expect(c.fallbackFor(r, main, hystrix.ErrTimeout, false)).To(Equal(loadTest))
+	})
+
+	It("doesn't fall back without a fallback client", func() {
+		c.fallbackName = ""
+		Expect(c.fallbackFor(r, main, hystrix.ErrCircuitOpen, true)).To(BeNil())
+	})
+})
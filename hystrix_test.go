@@ -120,7 +120,7 @@ var _ = Describe("hystrix in CLUSTER MODE", func() {
 		defer client.ShutDown(context.Background())
 		// for cluster, the cbKey might not equal to config.Main.Addrs[0], therefore we need to find the cbKey via ForEachShard
 		var cbKeys []string
-		_ = client.(*connectorImpl).client.wrappedClient.(*clusterWrapperImpl).ForEachShard(context.Background(), func(ctx context.Context, client *goredis.Client) error {
+		_ = client.(*connectorImpl).mainClient().wrappedClient.(*clusterWrapperImpl).ForEachShard(context.Background(), func(ctx context.Context, client *goredis.Client) error {
 			cbKeys = append(cbKeys, generateCBKey(client.Options().Addr))
 			return nil
 		})
@@ -155,7 +155,7 @@ var _ = Describe("hystrix in CLUSTER MODE", func() {
 		defer client.ShutDown(context.Background())
 		// for cluster, the cbKey might not equal to config.Main.Addrs[0], therefore we need to find the cbKey via ForEachShard
 		var cbKeys []string
-		_ = client.(*connectorImpl).client.wrappedClient.(*clusterWrapperImpl).ForEachShard(context.Background(), func(ctx context.Context, client *goredis.Client) error {
+		_ = client.(*connectorImpl).mainClient().wrappedClient.(*clusterWrapperImpl).ForEachShard(context.Background(), func(ctx context.Context, client *goredis.Client) error {
 			cbKeys = append(cbKeys, generateCBKey(client.Options().Addr))
 			return nil
 		})
@@ -0,0 +1,109 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"strings"
+)
+
+// rewriteRequest applies the key rewrite rules of the load test client to the request
+func (c *connectorImpl) rewriteRequest(client *clientImpl, req *loadTestRequest) *loadTestRequest {
+	if len(client.config.KeyRewriteRules) == 0 {
+		return req
+	}
+
+	return c.rewriteKeys(req, client.config.rewriteKey)
+}
+
+// rewriteKey applies the key rewrite rules in order, the key is returned as it is without a rule
+func (c *ClientConfig) rewriteKey(key string) string {
+	for _, rule := range c.KeyRewriteRules {
+		key = rule.rewrite(key)
+	}
+	return key
+}
+
+func (r *KeyRewriteRule) rewrite(key string) string {
+	switch r.Action {
+	case RewriteAddPrefix:
+		return r.Prefix + key
+	case RewriteStripPrefix:
+		return strings.TrimPrefix(key, r.Prefix)
+	case RewriteRegex:
+		return r.pattern.ReplaceAllString(key, r.Replacement)
+	case RewriteHashTag:
+		if _, ok := hashTag(key); ok {
+			return key
+		}
+		match := r.pattern.FindStringSubmatchIndex(key)
+		if match == nil || match[2] < 0 || match[2] == match[3] {
+			// an empty {} is not a hash tag
+			return key
+		}
+		return key[:match[2]] + "{" + key[match[2]:match[3]] + "}" + key[match[3]:]
+	}
+	return key
+}
+
+// rewriteKeys returns a copy of the request with all the keys rewritten by fn, the keys are found by the key specs of
+// the cmds, and the keys of a script are the first KeyCount of keysAndArgs.
+func (c *connectorImpl) rewriteKeys(req *loadTestRequest, fn func(key string) string) *loadTestRequest {
+	rewritten := *req
+	if req.cmds != nil {
+		rewritten.cmds = make([][]interface{}, len(req.cmds))
+		for i, cmd := range req.cmds {
+			positions := c.mainClient().keyPositions(cmd)
+			if len(positions) == 0 {
+				rewritten.cmds[i] = cmd
+				continue
+			}
+
+			newCmd := make([]interface{}, len(cmd))
+			copy(newCmd, cmd)
+			for _, pos := range positions {
+				newCmd[pos] = fn(argToString(cmd[pos]))
+			}
+			rewritten.cmds[i] = newCmd
+		}
+	}
+
+	if req.script != nil {
+		keyCount := req.script.KeyCount()
+		if keyCount > len(req.keysAndArgs) {
+			keyCount = len(req.keysAndArgs)
+		}
+		if keyCount > 0 {
+			rewritten.keysAndArgs = make([]interface{}, len(req.keysAndArgs))
+			copy(rewritten.keysAndArgs, req.keysAndArgs)
+			for i := 0; i < keyCount; i++ {
+				rewritten.keysAndArgs[i] = fn(argToString(req.keysAndArgs[i]))
+			}
+		}
+	}
+
+	return &rewritten
+}
@@ -0,0 +1,151 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	goredis "github.com/grab/redis/v8"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/grab/grab-redis/redisapi"
+)
+
+var _ = Describe("Test Key Rewrite", func() {
+	var c *connectorImpl
+	var r *routing
+	var loadTest *clientImpl
+
+	rules := func(rules ...*KeyRewriteRule) []*KeyRewriteRule {
+		for _, rule := range rules {
+			Expect(rule.initAndValidate()).To(Succeed())
+		}
+		return rules
+	}
+
+	BeforeEach(func() {
+		r = &routing{
+			client: &clientImpl{
+				cmdCache: map[string]*goredis.CommandInfo{
+					"set":  {Name: "set", FirstKeyPos: 1, LastKeyPos: 1, StepCount: 1},
+					"mget": {Name: "mget", FirstKeyPos: 1, LastKeyPos: -1, StepCount: 1},
+				},
+			},
+		}
+		c = &connectorImpl{}
+		c.routing.Store(r)
+		loadTest = &clientImpl{config: &ClientConfig{}}
+	})
+
+	It("adds and strips the prefix", func() {
+		loadTest.config.KeyRewriteRules = rules(
+			&KeyRewriteRule{Action: RewriteStripPrefix, Prefix: "old:"},
+			&KeyRewriteRule{Action: RewriteAddPrefix, Prefix: "new:"},
+		)
+		req := c.requestFor(r, loadTest, newDoRequest("MGET", []interface{}{"old:a", "b"}))
+		Expect(req.cmds).To(Equal([][]interface{}{{"MGET", "new:a", "new:b"}}))
+	})
+
+	It("rewrites the keys by regexp", func() {
+		loadTest.config.KeyRewriteRules = rules(&KeyRewriteRule{Action: RewriteRegex, Pattern: `^user:(\d+)$`, Replacement: "u:$1"})
+		req := c.requestFor(r, loadTest, newDoRequest("SET", []interface{}{"user:1", "user:2"}))
+		Expect(req.cmds).To(Equal([][]interface{}{{"SET", "u:1", "user:2"}}))
+	})
+
+	It("inserts the hash tag", func() {
+		loadTest.config.KeyRewriteRules = rules(&KeyRewriteRule{Action: RewriteHashTag})
+		req := c.requestFor(r, loadTest, newPipelineRequest([][]interface{}{
+			{"SET", "tenant1:user:1", "v"},
+			{"SET", "{tenant2}:user:1", "v"},
+		}))
+		Expect(req.cmds).To(Equal([][]interface{}{
+			{"SET", "{tenant1}:user:1", "v"},
+			{"SET", "{tenant2}:user:1", "v"},
+		}))
+	})
+
+	It("rewrites the keys of a script", func() {
+		loadTest.config.KeyRewriteRules = rules(&KeyRewriteRule{Action: RewriteAddPrefix, Prefix: "new:"})
+		req := c.requestFor(r, loadTest, newRunRequest(redisapi.NewScript(1, "return 1"), []interface{}{"k", "arg"}))
+		Expect(req.keysAndArgs).To(Equal([]interface{}{"new:k", "arg"}))
+	})
+
+	It("validates the rules", func() {
+		Expect((&KeyRewriteRule{Action: "rename"}).initAndValidate()).NotTo(Succeed())
+		Expect((&KeyRewriteRule{Action: RewriteAddPrefix}).initAndValidate()).NotTo(Succeed())
+		Expect((&KeyRewriteRule{Action: RewriteRegex, Pattern: "("}).initAndValidate()).NotTo(Succeed())
+		Expect((&KeyRewriteRule{Action: RewriteHashTag, Pattern: "^tenant"}).initAndValidate()).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("Test Key Rewrite routing", func() {
+	rules := func() []*KeyRewriteRule {
+		return []*KeyRewriteRule{{Action: RewriteAddPrefix, Prefix: "new:"}}
+	}
+
+	config := func(phase MigrationPhase) *ConnectorConfig {
+		return &ConnectorConfig{
+			Main:           &ClientConfig{Addrs: []string{"old:6379"}, ClientMode: ModeSingleHost},
+			LoadTests:      []*ClientConfig{{Addrs: []string{"new:6379"}, ClientMode: ModeSingleHost, KeyRewriteRules: rules()}},
+			MigrationPhase: phase,
+		}
+	}
+
+	It("applies the rules to a key", func() {
+		target := &ClientConfig{KeyRewriteRules: rules()}
+		Expect(target.KeyRewriteRules[0].initAndValidate()).To(Succeed())
+		Expect(target.rewriteKey("user:1")).To(Equal("new:user:1"))
+		Expect((&ClientConfig{}).rewriteKey("user:1")).To(Equal("user:1"))
+	})
+
+	It("rejects the phases routing the traffic to the rewritten client", func() {
+		Expect(config(PhaseDualWrite).initAndValidate()).To(Succeed())
+		Expect(config(PhaseShadowRead).initAndValidate()).To(Succeed())
+		Expect(config(PhaseReadFromNew).initAndValidate()).NotTo(Succeed())
+		Expect(config(PhaseCutover).initAndValidate()).NotTo(Succeed())
+		Expect(config(PhaseReadThrough).initAndValidate()).NotTo(Succeed())
+	})
+
+	It("rejects the rewritten client as the fallback client", func() {
+		c := config(PhaseDualWrite)
+		c.Fallback = &ClientConfig{Addrs: []string{"new:6379"}, ClientMode: ModeSingleHost}
+		Expect(c.initAndValidate()).NotTo(Succeed())
+
+		c = config(PhaseDualWrite)
+		c.Fallback = &ClientConfig{Addrs: []string{"replica:6379"}, ClientMode: ModeSingleHost, KeyRewriteRules: rules()}
+		Expect(c.initAndValidate()).NotTo(Succeed())
+	})
+
+	It("rejects promoting the rewritten client", func() {
+		main := &clientImpl{config: &ClientConfig{Addrs: []string{"old:6379"}, ClientMode: ModeSingleHost}}
+		loadTest := &clientImpl{config: &ClientConfig{Addrs: []string{"new:6379"}, ClientMode: ModeSingleHost, KeyRewriteRules: rules()}}
+		c := &connectorImpl{}
+		c.routing.Store(&routing{client: main, loadTestClients: []*clientImpl{loadTest}})
+		_, err := c.promote("new:6379", true)
+		Expect(err).To(HaveOccurred())
+		Expect(c.mainClient()).To(Equal(main))
+	})
+})
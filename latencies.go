@@ -29,6 +29,8 @@
 package redis
 
 import (
+	"math"
+	"sort"
 	"sync/atomic"
 )
 
@@ -59,16 +61,7 @@ func (l *latencies) Add(value int64) {
 func (l *latencies) Average() float64 {
 	var total int64
 
-	// we cannot use index since index is incremented before the swap occurs
-	added := int(atomic.LoadInt64(&l.added))
-
-	var length int
-	if added < len(l.values) {
-		length = added
-	} else {
-		length = len(l.values)
-	}
-
+	length := l.length()
 	if length <= 0 {
 		return 0.0
 	}
@@ -80,3 +73,46 @@ func (l *latencies) Average() float64 {
 
 	return float64(total) / float64(length)
 }
+
+// Percentile returns the value at the percentile p (0 to 1) of the latest values, e.g. 0.99 for p99.
+func (l *latencies) Percentile(p float64) float64 {
+	return l.Percentiles(p)[0]
+}
+
+// Percentiles returns the values at the percentiles, the values are sorted once for all the percentiles.
+func (l *latencies) Percentiles(ps ...float64) []float64 {
+	result := make([]float64, len(ps))
+	length := l.length()
+	if length <= 0 {
+		return result
+	}
+
+	values := make([]int64, length)
+	for i := 0; i < length; i++ {
+		values[i] = atomic.LoadInt64(&l.values[i])
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	for i, p := range ps {
+		// nearest-rank method
+		rank := int(math.Ceil(p*float64(length))) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		if rank >= length {
+			rank = length - 1
+		}
+		result[i] = float64(values[rank])
+	}
+	return result
+}
+
+// length returns the number of values being filled
+func (l *latencies) length() int {
+	// we cannot use index since index is incremented before the swap occurs
+	added := int(atomic.LoadInt64(&l.added))
+	if added < len(l.values) {
+		return added
+	}
+	return len(l.values)
+}
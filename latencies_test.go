@@ -0,0 +1,63 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test latencies", func() {
+	It("returns the percentiles of the latest values", func() {
+		l := newLatencies(100)
+		Expect(l.Percentile(0.99)).To(BeZero())
+
+		for i := 1; i <= 100; i++ {
+			l.Add(int64(i))
+		}
+		Expect(l.Percentiles(0.5, 0.95, 0.99, 1)).To(Equal([]float64{50, 95, 99, 100}))
+		Expect(l.Percentile(0)).To(Equal(float64(1)))
+
+		// the oldest values are replaced
+		for i := 0; i < 100; i++ {
+			l.Add(1000)
+		}
+		Expect(l.Percentile(0.5)).To(Equal(float64(1000)))
+		Expect(l.Average()).To(Equal(float64(1000)))
+	})
+
+	It("keeps the tail out of the median", func() {
+		l := newLatencies(10)
+		for i := 0; i < 9; i++ {
+			l.Add(10)
+		}
+		l.Add(10000)
+		Expect(l.Average()).To(BeNumerically(">", 1000))
+		Expect(l.Percentile(0.5)).To(Equal(float64(10)))
+		Expect(l.Percentile(0.99)).To(Equal(float64(10000)))
+	})
+})
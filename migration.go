@@ -0,0 +1,104 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import "fmt"
+
+// migrationTransitions lists the phases that each phase is allowed to move to, an empty phase is treated as PhaseOff
+var migrationTransitions = map[MigrationPhase][]MigrationPhase{
+	PhaseOff:         {PhaseDualWrite, PhaseReadThrough},
+	PhaseDualWrite:   {PhaseShadowRead, PhaseOff},
+	PhaseShadowRead:  {PhaseReadFromNew, PhaseDualWrite, PhaseOff},
+	PhaseReadFromNew: {PhaseCutover, PhaseShadowRead, PhaseRollback},
+	PhaseCutover:     {PhaseRollback},
+	PhaseRollback:    {PhaseOff, PhaseDualWrite},
+	PhaseReadThrough: {PhaseCutover, PhaseRollback},
+}
+
+func validatePhaseTransition(from MigrationPhase, to MigrationPhase) error {
+	if from == "" {
+		from = PhaseOff
+	}
+	if to == "" {
+		to = PhaseOff
+	}
+	if from == to || to.In(migrationTransitions[from]...) {
+		return nil
+	}
+
+	return fmt.Errorf("migration phase change from %s to %s is not allowed", from, to)
+}
+
+// mainClient returns the main client of the current routing, e.g. for the load test tasks and the key specs of the cmds
+func (c *connectorImpl) mainClient() *clientImpl {
+	return c.loadRouting().client
+}
+
+// readClient returns the client serving the read-only cmds
+func (r *routing) readClient() *clientImpl {
+	if r.phase.In(PhaseReadFromNew, PhaseCutover, PhaseReadThrough) {
+		return r.loadTestClients[0]
+	}
+	return r.client
+}
+
+// writeClient returns the client serving the cmds which are not read-only
+func (r *routing) writeClient() *clientImpl {
+	if r.phase.In(PhaseCutover, PhaseReadThrough) {
+		return r.loadTestClients[0]
+	}
+	return r.client
+}
+
+// mirrorClients returns the clients receiving the same writes as the write client
+func (r *routing) mirrorClients() []*clientImpl {
+	switch r.phase {
+	case PhaseOff, PhaseRollback, PhaseReadThrough:
+		return nil
+	case PhaseCutover:
+		// keep the old cluster up to date for rollback
+		return []*clientImpl{r.client}
+	default:
+		return r.loadTestClients
+	}
+}
+
+// ignoreReadOnly returns whether the read-only cmds are not sent to the mirror clients
+func (r *routing) ignoreReadOnly() bool {
+	if r.phase == "" {
+		return r.client.config.IgnoreReadOnly
+	}
+	return true
+}
+
+// isShadowRead returns whether the read-only cmds are compared with the load test clients
+func (r *routing) isShadowRead() bool {
+	if r.phase == "" {
+		return r.shadowRead
+	}
+	return r.phase == PhaseShadowRead
+}
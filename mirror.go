@@ -0,0 +1,196 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+
+	"github.com/grab/grab-redis/redisapi"
+)
+
+// loadTestRequest keeps the request sent to the main client, so it can be sampled before being sent to a load test client
+type loadTestRequest struct {
+	// function is one of tagFunctionDo, tagFunctionPipeline, tagFunctionRun and tagFunctionPublish
+	function string
+	// cmds of Do, Pipeline and Publish, each cmd starts with the cmd name
+	cmds [][]interface{}
+
+	script      *redisapi.Script
+	keysAndArgs []interface{}
+
+	// readonly requests are not sent to the dead letter sink when they fail
+	readonly bool
+}
+
+func newDoRequest(cmdName string, args []interface{}) *loadTestRequest {
+	return &loadTestRequest{
+		function: tagFunctionDo,
+		cmds:     [][]interface{}{append([]interface{}{cmdName}, args...)},
+	}
+}
+
+func newPipelineRequest(argsList [][]interface{}) *loadTestRequest {
+	return &loadTestRequest{
+		function: tagFunctionPipeline,
+		cmds:     argsList,
+	}
+}
+
+func newRunRequest(script *redisapi.Script, keysAndArgs []interface{}) *loadTestRequest {
+	return &loadTestRequest{
+		function:    tagFunctionRun,
+		script:      script,
+		keysAndArgs: keysAndArgs,
+	}
+}
+
+func newPublishRequest(channelName string, value interface{}) *loadTestRequest {
+	return &loadTestRequest{
+		function: tagFunctionPublish,
+		cmds:     [][]interface{}{{redisPublish, channelName, value}},
+	}
+}
+
+// execute sends the request to the load test client
+func (r *loadTestRequest) execute(ctx context.Context, client *clientImpl) error {
+	var err error
+	switch r.function {
+	case tagFunctionDo:
+		_, err = client.Do(ctx, r.cmds[0][0].(string), r.cmds[0][1:]...)
+	case tagFunctionPipeline:
+		_, err = client.Pipeline(ctx, r.cmds)
+	case tagFunctionRun:
+		_, err = client.Run(ctx, r.script, r.keysAndArgs...)
+	case tagFunctionPublish:
+		_, err = client.Publish(ctx, r.cmds[0][1].(string), r.cmds[0][2])
+	}
+	return err
+}
+
+// ops returns the number of operations of the request for the rate limit, a pipeline counts as its number of cmds
+func (r *loadTestRequest) ops() int {
+	if r.function == tagFunctionPipeline {
+		return len(r.cmds)
+	}
+	return 1
+}
+
+// firstKey returns the first key of the request, the cmds without key and Publish have no key
+func (r *loadTestRequest) firstKey(cmdCache *clientImpl) (string, bool) {
+	switch r.function {
+	case tagFunctionDo, tagFunctionPipeline:
+		if len(r.cmds) > 0 {
+			return cmdCache.firstKey(r.cmds[0])
+		}
+	case tagFunctionRun:
+		if r.script.KeyCount() > 0 && len(r.keysAndArgs) > 0 {
+			return argToString(r.keysAndArgs[0]), true
+		}
+	}
+	return "", false
+}
+
+// requestFor returns the request to be sent to the load test client, or nil if nothing needs to be sent
+func (c *connectorImpl) requestFor(r *routing, client *clientImpl, req *loadTestRequest) *loadTestRequest {
+	req = c.filterRequest(r, client, req)
+	if req == nil {
+		return nil
+	}
+	req = c.sampleRequest(r, client, req)
+	if req == nil {
+		return nil
+	}
+	return c.rewriteRequest(client, req)
+}
+
+// sampleRequest applies the sample rate of the load test client to the request
+func (c *connectorImpl) sampleRequest(r *routing, client *clientImpl, req *loadTestRequest) *loadTestRequest {
+	config := client.config
+	if config.SampleRate <= 0 || config.SampleRate >= 1 {
+		return req
+	}
+
+	if config.SampleMode != SampleByKeyHash {
+		if rand.Float64() < config.SampleRate {
+			return req
+		}
+		return nil
+	}
+
+	// sample each cmd of a pipeline by its own key, so the same keys are always mirrored
+	if req.function == tagFunctionPipeline {
+		var cmds [][]interface{}
+		for _, cmd := range req.cmds {
+			key, ok := r.client.firstKey(cmd)
+			if !ok || isKeySampled(key, config.SampleRate) {
+				cmds = append(cmds, cmd)
+			}
+		}
+		if len(cmds) == 0 {
+			return nil
+		}
+		sampled := *req
+		sampled.cmds = cmds
+		return &sampled
+	}
+
+	key, ok := req.firstKey(r.client)
+	if !ok || isKeySampled(key, config.SampleRate) {
+		return req
+	}
+	return nil
+}
+
+// isKeySampled decides by the hash of the key, so the keys in the same slot are sampled together
+func isKeySampled(key string, rate float64) bool {
+	// the high bits of fnv are barely changed by the last bytes of the key, so the low bits are used
+	return float64(keyHash(key)%sampleHashBuckets)/sampleHashBuckets < rate
+}
+
+// keyHash returns the fnv hash of the key, the hash tag is used if the key has one like the slot of redis cluster
+func keyHash(key string) uint64 {
+	if tag, ok := hashTag(key); ok {
+		key = tag
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// hashTag returns the content of the first {...} in the key, an empty {} is not a hash tag
+func hashTag(key string) (string, bool) {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			return key[start+1 : start+1+end], true
+		}
+	}
+	return "", false
+}
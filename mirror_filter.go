@@ -0,0 +1,157 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"regexp"
+	"strings"
+)
+
+// filterRequest applies the mirror rules to the request, it returns nil if nothing is allowed to be sent to the load test client
+func (c *connectorImpl) filterRequest(r *routing, client *clientImpl, req *loadTestRequest) *loadTestRequest {
+	rules := r.mirrorRules
+	if len(rules) == 0 {
+		return req
+	}
+
+	name := client.config.name()
+	switch req.function {
+	case tagFunctionPipeline:
+		var cmds [][]interface{}
+		for _, cmd := range req.cmds {
+			key, hasKey := r.client.firstKey(cmd)
+			if isMirrored(rules, name, cmdNameOf(cmd), key, hasKey) {
+				cmds = append(cmds, cmd)
+			}
+		}
+		if len(cmds) == 0 {
+			return nil
+		}
+		if len(cmds) == len(req.cmds) {
+			return req
+		}
+		filtered := *req
+		filtered.cmds = cmds
+		return &filtered
+	case tagFunctionDo, tagFunctionPublish:
+		key, hasKey := r.client.firstKey(req.cmds[0])
+		if isMirrored(rules, name, cmdNameOf(req.cmds[0]), key, hasKey) {
+			return req
+		}
+	case tagFunctionRun:
+		key, hasKey := req.firstKey(r.client)
+		if isMirrored(rules, name, redisEval, key, hasKey) {
+			return req
+		}
+	}
+
+	return nil
+}
+
+// isMirrored returns the action of the first matched rule, if no rule is matched, the cmd is mirrored unless there is an allow
+// rule for the load test client.
+func isMirrored(rules []*MirrorRule, loadTest string, cmdName string, key string, hasKey bool) bool {
+	mirrored := true
+	for _, rule := range rules {
+		if !rule.appliesTo(loadTest) {
+			continue
+		}
+		if rule.matches(cmdName, key, hasKey) {
+			return rule.Action == MirrorAllow
+		}
+		if rule.Action == MirrorAllow {
+			mirrored = false
+		}
+	}
+	return mirrored
+}
+
+func (r *MirrorRule) appliesTo(loadTest string) bool {
+	if len(r.LoadTests) == 0 {
+		return true
+	}
+	for _, name := range r.LoadTests {
+		if name == loadTest {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MirrorRule) matches(cmdName string, key string, hasKey bool) bool {
+	if len(r.commands) > 0 && !r.commands[strings.ToLower(cmdName)] {
+		return false
+	}
+	if r.keyPattern != nil && (!hasKey || !r.keyPattern.MatchString(key)) {
+		return false
+	}
+	return true
+}
+
+func cmdNameOf(cmd []interface{}) string {
+	if len(cmd) == 0 {
+		return ""
+	}
+	return argToString(cmd[0])
+}
+
+// globToRegexp converts a glob-style pattern of redis, e.g. user:*, h?llo or h[ae]llo, to a regexp
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			sb.WriteString("(?s:.*)")
+		case '?':
+			sb.WriteString("(?s:.)")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "^") {
+				class = "^" + strings.ReplaceAll(class[1:], `\`, `\\`)
+			} else {
+				class = strings.ReplaceAll(class, `\`, `\\`)
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
@@ -71,6 +71,163 @@ func (m ReadMode) IsValid() bool {
 	return m.In(ModeReadFromMaster, ModeReadFromSlaves, ModeReadRandomly, ModeReadByLatency)
 }
 
+type SampleMode string
+
+const (
+	// SampleRandom mirrors each request randomly by the sample rate.
+	SampleRandom SampleMode = "random"
+	// SampleByKeyHash mirrors the requests by the hash of the first key, so the same subset of keys is always mirrored.
+	SampleByKeyHash SampleMode = "keyHash"
+)
+
+func (m SampleMode) In(modes ...SampleMode) bool {
+	for _, mode := range modes {
+		if m == mode {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m SampleMode) IsValid() bool {
+	return m.In(SampleRandom, SampleByKeyHash)
+}
+
+type MigrationPhase string
+
+const (
+	// PhaseOff only sends the traffic to the main client.
+	PhaseOff MigrationPhase = "off"
+	// PhaseDualWrite sends the writes to both the main client and the load test clients, the reads only go to the main client.
+	PhaseDualWrite MigrationPhase = "dualWrite"
+	// PhaseShadowRead is PhaseDualWrite plus comparing the replies of the reads from the load test clients with the main client.
+	PhaseShadowRead MigrationPhase = "shadowRead"
+	// PhaseReadFromNew serves the reads from the first load test client, the writes still go to both sides.
+	PhaseReadFromNew MigrationPhase = "readFromNew"
+	// PhaseCutover serves both reads and writes from the first load test client, and writes back to the main client for rollback.
+	PhaseCutover MigrationPhase = "cutover"
+	// PhaseRollback sends all the traffic back to the main client, the load test clients no longer receive any traffic.
+	PhaseRollback MigrationPhase = "rollback"
+	// PhaseReadThrough serves both reads and writes from the first load test client, the reads missed in it are read
+	// from the main client and copied to it with the remaining TTL, and the deletes go to both sides.
+	PhaseReadThrough MigrationPhase = "readThrough"
+)
+
+func (p MigrationPhase) In(phases ...MigrationPhase) bool {
+	for _, phase := range phases {
+		if p == phase {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p MigrationPhase) IsValid() bool {
+	return p.In(PhaseOff, PhaseDualWrite, PhaseShadowRead, PhaseReadFromNew, PhaseCutover, PhaseRollback, PhaseReadThrough)
+}
+
+type SyncWritePolicy string
+
+const (
+	// SyncWriteFail fails the call when the write to the load test client fails.
+	SyncWriteFail SyncWritePolicy = "fail"
+	// SyncWriteLog logs the failure and returns the result of the main client.
+	SyncWriteLog SyncWritePolicy = "log"
+	// SyncWriteRetry retries the write to the load test client, and fails the call if all the retries failed.
+	SyncWriteRetry SyncWritePolicy = "retry"
+)
+
+func (p SyncWritePolicy) In(policies ...SyncWritePolicy) bool {
+	for _, policy := range policies {
+		if p == policy {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p SyncWritePolicy) IsValid() bool {
+	return p.In(SyncWriteFail, SyncWriteLog, SyncWriteRetry)
+}
+
+type BackfillMode string
+
+const (
+	// BackfillAuto copies the keys with DUMP/RESTORE, and falls back to BackfillTyped when DUMP/RESTORE is not allowed, e.g. AWS ElastiCache.
+	BackfillAuto BackfillMode = "auto"
+	// BackfillDump copies the keys with DUMP/RESTORE only.
+	BackfillDump BackfillMode = "dump"
+	// BackfillTyped reads the keys by their types, e.g. GET, HGETALL, ZRANGE WITHSCORES, and writes them to the target.
+	BackfillTyped BackfillMode = "typed"
+)
+
+func (m BackfillMode) In(modes ...BackfillMode) bool {
+	for _, mode := range modes {
+		if m == mode {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m BackfillMode) IsValid() bool {
+	return m.In(BackfillAuto, BackfillDump, BackfillTyped)
+}
+
+type MirrorAction string
+
+const (
+	// MirrorAllow mirrors the matched cmds.
+	MirrorAllow MirrorAction = "allow"
+	// MirrorDeny doesn't mirror the matched cmds.
+	MirrorDeny MirrorAction = "deny"
+)
+
+func (a MirrorAction) In(actions ...MirrorAction) bool {
+	for _, action := range actions {
+		if a == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a MirrorAction) IsValid() bool {
+	return a.In(MirrorAllow, MirrorDeny)
+}
+
+type KeyRewriteAction string
+
+const (
+	// RewriteAddPrefix adds the prefix to the keys.
+	RewriteAddPrefix KeyRewriteAction = "addPrefix"
+	// RewriteStripPrefix removes the prefix from the keys starting with it.
+	RewriteStripPrefix KeyRewriteAction = "stripPrefix"
+	// RewriteRegex replaces the matches of the pattern by the replacement.
+	RewriteRegex KeyRewriteAction = "regex"
+	// RewriteHashTag wraps the first group matched by the pattern as the hash tag.
+	RewriteHashTag KeyRewriteAction = "hashTag"
+)
+
+func (a KeyRewriteAction) In(actions ...KeyRewriteAction) bool {
+	for _, action := range actions {
+		if a == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a KeyRewriteAction) IsValid() bool {
+	return a.In(RewriteAddPrefix, RewriteStripPrefix, RewriteRegex, RewriteHashTag)
+}
+
 // Hystrix circuit breaker setting
 type Hystrix struct {
 	// TimeoutInMs is how long to wait for command to complete, in milliseconds
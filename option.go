@@ -53,6 +53,13 @@ func ConnectorCBOptions(cbOptions []circuitbreaker.Option) ConnectorOption {
 	}
 }
 
+// ConnectorDeadLetterSink specifies the sink of the async mirror writes still failing after the retries
+func ConnectorDeadLetterSink(sink DeadLetterSink) ConnectorOption {
+	return func(c *connectorImpl) {
+		c.deadLetterSink = sink
+	}
+}
+
 // ClientOption is a functional parameter used to configure the clientImpl
 type ClientOption func(client *clientImpl)
 
@@ -0,0 +1,165 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Promoter makes a load test client the main client at runtime, the connectors created by NewStaticConnector and
+// NewDynamicConnector implement it.
+type Promoter interface {
+	// Promote makes the load test client of the name the main client, the name is the same as the host tag of the metrics.
+	// The old main client becomes the first load test client receiving the mirrored writes if demote is true, so the
+	// cutover can be rolled back by promoting it again, otherwise it is shut down.
+	Promote(ctx context.Context, loadTest string, demote bool) error
+}
+
+// Promote swaps the main client with the load test client, the new cmds are routed to the new main client right away
+// while the cmds in flight finish on the old one. The backlog of the queued mirror writes is drained before the swap, and
+// the old main client is shut down once the cmds in flight and the queued mirror writes are done if demote is false. The
+// migration phase is reset, and the config should be updated to the new main and load test clients, as reloading the old
+// main client is rejected until then.
+func (c *connectorImpl) Promote(ctx context.Context, loadTest string, demote bool) error {
+	c.swapMu.Lock()
+	defer c.swapMu.Unlock()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultPromoteTimeout)
+		defer cancel()
+	}
+
+	// the queued mirror writes are older than the cmds sent to the new main client after the swap
+	err := c.waitScheduler(ctx, func() bool { return c.loadTestScheduler.backlog() == 0 })
+	if err != nil {
+		c.logger.Warn(pkgName, "unable to promote load test client %s, Error: %s", loadTest, err)
+		return err
+	}
+
+	old, err := c.promote(loadTest, demote)
+	if err != nil {
+		c.logger.Warn(pkgName, "unable to promote load test client %s, Error: %s", loadTest, err)
+		return err
+	}
+
+	c.promoted = true
+	c.stats.Count1(pkgName, metricPromoted, c.mainClient().getTags(tagFunctionPromote))
+	c.logger.Info(pkgName, "promoted load test client %s to main client", loadTest)
+	if !demote {
+		c.retire(old, old.client)
+	}
+	return nil
+}
+
+// promote stores the routing with the load test client as the main client and returns the old routing
+func (c *connectorImpl) promote(loadTest string, demote bool) (*routing, error) {
+	current := c.loadRouting()
+	index := -1
+	for i, client := range current.loadTestClients {
+		if client.config.name() == loadTest {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("load test client %s is not found", loadTest)
+	}
+	// the main client serves the original keys
+	if len(current.loadTestClients[index].config.KeyRewriteRules) > 0 {
+		return nil, fmt.Errorf("load test client %s with key rewrite rules can't be promoted", loadTest)
+	}
+
+	old, promoted := current.client, current.loadTestClients[index]
+	loadTestClients := make([]*clientImpl, 0, len(current.loadTestClients))
+	if demote {
+		loadTestClients = append(loadTestClients, old)
+	}
+	loadTestClients = append(loadTestClients, current.loadTestClients[:index]...)
+	loadTestClients = append(loadTestClients, current.loadTestClients[index+1:]...)
+
+	// IgnoreReadOnly of the main client decides the routing of the connector
+	promoted.config.IgnoreReadOnly = old.config.IgnoreReadOnly
+	c.routing.Store(&routing{
+		client:                    promoted,
+		loadTestClients:           loadTestClients,
+		processAllLoadTestPackets: current.processAllLoadTestPackets,
+		shadowRead:                current.shadowRead,
+		shadowReadLogSampleRate:   current.shadowReadLogSampleRate,
+		mirrorRules:               current.mirrorRules,
+		fallbackWrites:            current.fallbackWrites,
+	})
+
+	return current, nil
+}
+
+// newMainClient creates and warms up a new main client for the config changes which can't be reloaded in place, i.e.
+// Addrs, ClientMode and DB, while the old one serves the traffic.
+func (c *connectorImpl) newMainClient(ctx context.Context, config *ClientConfig) (*clientImpl, error) {
+	client, err := newClient(ctx, config, ClientStatsD(c.stats), ClientLogger(c.logger), ClientCBOptions(c.cbOptions))
+	if err != nil {
+		return nil, err
+	}
+	if err = client.warmUp(ctx); err != nil {
+		client.ShutDown(ctx)
+		return nil, err
+	}
+	return client, nil
+}
+
+// retire shuts down the clients removed from the old routing in the background, once the cmds routed by the old routing
+// and the queued mirror writes, which may still be sent to the clients, are done
+func (c *connectorImpl) retire(old *routing, clients ...*clientImpl) {
+	if len(clients) == 0 {
+		return
+	}
+	go func() {
+		drainCtx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+		defer cancel()
+		_ = c.waitScheduler(drainCtx, func() bool { return old.drained() && c.loadTestScheduler.idle() })
+		for _, client := range clients {
+			client.ShutDown(context.Background())
+		}
+	}()
+}
+
+// waitScheduler waits until done returns true, e.g. the load test scheduler has no queued or running task
+func (c *connectorImpl) waitScheduler(ctx context.Context, done func() bool) error {
+	ticker := time.NewTicker(promoteDrainInterval)
+	defer ticker.Stop()
+
+	for !done() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
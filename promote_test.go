@@ -0,0 +1,291 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	goredis "github.com/grab/redis/v8"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.uber.org/atomic"
+
+	"github.com/grab/grab-redis/circuitbreaker"
+)
+
+var _ = Describe("Test Promote", func() {
+	var c *connectorImpl
+	var main, newMain, other *clientImpl
+	var mainWrapper *fakeClientWrapper
+
+	BeforeEach(func() {
+		mainWrapper = &fakeClientWrapper{}
+		main = &clientImpl{config: &ClientConfig{Addrs: []string{"old:6379"}, ClientMode: ModeSingleHost, IgnoreReadOnly: true},
+			wrappedClient: mainWrapper, closeChan: make(chan struct{}), stats: NewNoopStatsClient(), logger: NewNoopLogger()}
+		newMain = &clientImpl{config: &ClientConfig{Addrs: []string{"new:6379"}, ClientMode: ModeSingleHost}}
+		other = &clientImpl{config: &ClientConfig{Addrs: []string{"other:6379"}, ClientMode: ModeSingleHost}}
+		c = &connectorImpl{
+			loadTestScheduler: newScheduler(&schedulerOptions{maxChanSize: 10, maxWorker: 1, workerIdleTimeout: time.Second}),
+			stats:             NewNoopStatsClient(),
+			logger:            NewNoopLogger(),
+		}
+		c.routing.Store(&routing{client: main, loadTestClients: []*clientImpl{other, newMain}, phase: PhaseCutover, shadowRead: true})
+	})
+
+	It("promotes the load test client and demotes the main client", func() {
+		Expect(c.Promote(context.Background(), "new:6379", true)).To(Succeed())
+		r := c.loadRouting()
+		Expect(r.client).To(Equal(newMain))
+		Expect(r.loadTestClients).To(Equal([]*clientImpl{main, other}))
+		Expect(r.phase).To(BeEmpty())
+		Expect(r.shadowRead).To(BeTrue())
+		Expect(newMain.config.IgnoreReadOnly).To(BeTrue())
+	})
+
+	It("rejects an unknown load test client", func() {
+		Expect(c.Promote(context.Background(), "unknown:6379", true)).NotTo(Succeed())
+		Expect(c.mainClient()).To(Equal(main))
+	})
+
+	It("doesn't wait for the cmds in flight", func() {
+		r := c.acquire()
+		Expect(c.Promote(context.Background(), "new:6379", true)).To(Succeed())
+		Expect(c.mainClient()).To(Equal(newMain))
+		// the cmd in flight keeps the routing it started with
+		Expect(r.client).To(Equal(main))
+		r.release()
+		Expect(r.drained()).To(BeTrue())
+	})
+
+	It("waits for the queued mirror writes", func() {
+		c.queue(c.loadRouting(), newMain, func(ctx context.Context, client *clientImpl) error { return nil }, newDoRequest("PING", nil))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		Expect(c.Promote(ctx, "new:6379", true)).To(Equal(context.DeadlineExceeded))
+		Expect(c.mainClient()).To(Equal(main))
+
+		schedulerCtx, stop := context.WithCancel(context.Background())
+		defer stop()
+		go c.loadTestScheduler.start(schedulerCtx)
+		Expect(c.Promote(context.Background(), "new:6379", true)).To(Succeed())
+		Expect(c.mainClient()).To(Equal(newMain))
+	})
+
+	It("doesn't block the cmds while draining the backlog", func() {
+		c.queue(c.loadRouting(), newMain, func(ctx context.Context, client *clientImpl) error { return nil }, newDoRequest("PING", nil))
+
+		done := make(chan error)
+		go func() {
+			done <- c.Promote(context.Background(), "new:6379", true)
+		}()
+		Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+		r := c.acquire()
+		Expect(r.client).To(Equal(main))
+		r.release()
+
+		schedulerCtx, stop := context.WithCancel(context.Background())
+		defer stop()
+		go c.loadTestScheduler.start(schedulerCtx)
+		Eventually(done).Should(Receive(BeNil()))
+		Expect(c.mainClient()).To(Equal(newMain))
+	})
+
+	It("shuts down the old main client after the cmds in flight", func() {
+		r := c.acquire()
+		Expect(c.Promote(context.Background(), "new:6379", false)).To(Succeed())
+		Expect(c.loadRouting().loadTestClients).To(Equal([]*clientImpl{other}))
+		Consistently(mainWrapper.closed.Load, 50*time.Millisecond).Should(BeFalse())
+
+		r.release()
+		Eventually(mainWrapper.closed.Load).Should(BeTrue())
+	})
+})
+
+var _ = Describe("Test Replace", func() {
+	config := func() *ClientConfig {
+		return &ClientConfig{Addrs: []string{"old:6379"}, ClientMode: ModeCluster, PoolSize: 10}
+	}
+
+	It("reloads the pool changes in place", func() {
+		newConfig := config()
+		newConfig.PoolSize = 20
+		Expect(config().requiresNewClient(newConfig)).To(BeFalse())
+	})
+
+	It("replaces the client on the address, mode or DB changes", func() {
+		newConfig := config()
+		newConfig.Addrs = []string{"new:6379"}
+		Expect(config().requiresNewClient(newConfig)).To(BeTrue())
+
+		newConfig = config()
+		newConfig.ClientMode = ModeSingleHost
+		Expect(config().requiresNewClient(newConfig)).To(BeTrue())
+
+		newConfig = config()
+		newConfig.DB = 1
+		Expect(config().requiresNewClient(newConfig)).To(BeTrue())
+	})
+
+	It("rejects sharing the address with a load test client before replacing the client", func() {
+		c := &connectorImpl{schedulerOptions: &schedulerOptions{}}
+		c.routing.Store(&routing{client: &clientImpl{config: config()}})
+		newConfig := &ConnectorConfig{Main: config(), LoadTests: []*ClientConfig{{Addrs: []string{"new:6379"}}}}
+		newConfig.Main.Addrs = []string{"new:6379"}
+		Expect(c.validateReload(newConfig)).NotTo(Succeed())
+	})
+
+	It("rejects reloading the old main client after promoting", func() {
+		c := &connectorImpl{schedulerOptions: &schedulerOptions{}, promoted: true}
+		c.routing.Store(&routing{client: &clientImpl{config: config()}})
+		oldConfig := &ConnectorConfig{Main: config(), LoadTests: []*ClientConfig{{Addrs: []string{"old:6379"}}}}
+		oldConfig.Main.Addrs = []string{"prev:6379"}
+		Expect(c.validateReload(oldConfig)).NotTo(Succeed())
+
+		newConfig := &ConnectorConfig{Main: config()}
+		newConfig.Main.init()
+		Expect(c.validateReload(newConfig)).To(Succeed())
+	})
+
+	It("returns the error of warming up", func() {
+		wrapper := &fakeClientWrapper{masterErr: errors.New("connection refused")}
+		client := &clientImpl{config: config(), wrappedClient: wrapper}
+		Expect(client.warmUp(context.Background())).To(MatchError("connection refused"))
+		Expect(wrapper.masters.Load()).To(Equal(int32(1)))
+	})
+
+	It("shuts down the old main client after the cmds in flight and the queued mirror writes", func() {
+		oldWrapper := &fakeClientWrapper{}
+		old := &clientImpl{config: config(), wrappedClient: oldWrapper, closeChan: make(chan struct{}),
+			stats: NewNoopStatsClient(), logger: NewNoopLogger()}
+		c := &connectorImpl{
+			loadTestScheduler: newScheduler(&schedulerOptions{maxChanSize: 10, maxWorker: 1, workerIdleTimeout: time.Second}),
+			stats:             NewNoopStatsClient(),
+			logger:            NewNoopLogger(),
+		}
+		c.routing.Store(&routing{client: old})
+		r := c.acquire()
+		c.queue(r, old, func(ctx context.Context, client *clientImpl) error { return nil }, newDoRequest("PING", nil))
+		c.routing.Store(&routing{client: &clientImpl{config: config()}})
+
+		c.retire(r, old)
+		r.release()
+		Consistently(oldWrapper.closed.Load, 50*time.Millisecond).Should(BeFalse())
+
+		schedulerCtx, stop := context.WithCancel(context.Background())
+		defer stop()
+		go c.loadTestScheduler.start(schedulerCtx)
+		Eventually(oldWrapper.closed.Load).Should(BeTrue())
+	})
+})
+
+var _ = Describe("Test Reload", func() {
+	var c *connectorImpl
+	var main, loadTest *clientImpl
+	var loadTestWrapper *fakeClientWrapper
+
+	newConfig := func() *ConnectorConfig {
+		config := &ConnectorConfig{
+			Main:      &ClientConfig{Addrs: []string{"old:6379"}, ClientMode: ModeSingleHost},
+			LoadTests: []*ClientConfig{{Addrs: []string{"load-test:6379"}, ClientMode: ModeSingleHost}},
+			HotReload: true,
+		}
+		Expect(config.initAndValidate()).To(Succeed())
+		return config
+	}
+	newFakeClient := func(config *ClientConfig, wrapper *fakeClientWrapper) *clientImpl {
+		return &clientImpl{config: config, wrappedClient: wrapper, closeChan: make(chan struct{}),
+			opsLimiter: newTokenBucket(0, 0), stats: NewNoopStatsClient(), logger: NewNoopLogger()}
+	}
+
+	BeforeEach(func() {
+		config := newConfig()
+		options := newSchedulerOptions(config)
+		options.normalise()
+		c = &connectorImpl{
+			schedulerOptions:  options,
+			loadTestScheduler: newScheduler(options),
+			stats:             NewNoopStatsClient(),
+			logger:            NewNoopLogger(),
+		}
+		main = newFakeClient(config.Main, &fakeClientWrapper{})
+		loadTestWrapper = &fakeClientWrapper{}
+		loadTest = newFakeClient(config.LoadTests[0], loadTestWrapper)
+		c.routing.Store(newRouting(config, main, []*clientImpl{loadTest}))
+	})
+
+	It("keeps the old config as a whole if a new client fails", func() {
+		config := newConfig()
+		config.SchedulerWorkerNumber = c.schedulerOptions.maxWorker + 1
+		config.LoadTests = []*ClientConfig{{Addrs: []string{"new-load-test:6379"}, ClientMode: ModeSingleHost}}
+		Expect(config.initAndValidate()).To(Succeed())
+		current := c.loadRouting()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		Expect(c.reload(ctx, config)).NotTo(Succeed())
+		Expect(c.loadRouting()).To(BeIdenticalTo(current))
+		Expect(c.schedulerOptions.maxWorker).NotTo(Equal(config.SchedulerWorkerNumber))
+		Consistently(loadTestWrapper.closed.Load, 50*time.Millisecond).Should(BeFalse())
+	})
+
+	It("shuts down the removed load test clients after the cmds in flight", func() {
+		config := newConfig()
+		config.LoadTests = nil
+		r := c.acquire()
+
+		Expect(c.reload(context.Background(), config)).To(Succeed())
+		Expect(c.loadRouting().loadTestClients).To(BeEmpty())
+		Consistently(loadTestWrapper.closed.Load, 50*time.Millisecond).Should(BeFalse())
+
+		r.release()
+		Eventually(loadTestWrapper.closed.Load).Should(BeTrue())
+	})
+})
+
+type fakeClientWrapper struct {
+	clientWrapper
+	masterErr error
+	masters   atomic.Int32
+	closed    atomic.Bool
+}
+
+func (f *fakeClientWrapper) forEachMaster(ctx context.Context, fn func(ctx context.Context, client *goredis.Client) error) error {
+	f.masters.Inc()
+	return f.masterErr
+}
+
+func (f *fakeClientWrapper) reload(config *ClientConfig, cbOptions []circuitbreaker.Option) error {
+	return nil
+}
+
+func (f *fakeClientWrapper) Close() error {
+	f.closed.Store(true)
+	return nil
+}
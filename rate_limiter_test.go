@@ -0,0 +1,103 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test tokenBucket", func() {
+	It("allows the burst and then the rate", func() {
+		b := newTokenBucket(100, 2)
+		Expect(b.allow(1)).To(BeTrue())
+		Expect(b.allow(1)).To(BeTrue())
+		Expect(b.allow(1)).To(BeFalse())
+		Eventually(func() bool { return b.allow(1) }, time.Second, time.Millisecond).Should(BeTrue())
+	})
+
+	It("charges the debt of a batch larger than the burst", func() {
+		b := newTokenBucket(100, 5)
+		Expect(b.allow(100)).To(BeTrue())
+		Expect(b.tokens).To(BeNumerically("<", -94))
+		Expect(b.allow(1)).To(BeFalse())
+		// the debt of 95 tokens is paid in about a second
+		Consistently(func() bool { return b.allow(1) }, 500*time.Millisecond, 10*time.Millisecond).Should(BeFalse())
+		Eventually(func() bool { return b.allow(1) }, time.Second, time.Millisecond).Should(BeTrue())
+	})
+
+	It("updates the rate and the burst", func() {
+		b := newTokenBucket(1, 10)
+		b.update(1, 1)
+		Expect(b.allow(1)).To(BeTrue())
+		Expect(b.allow(1)).To(BeFalse())
+
+		b.update(1000, 1)
+		Eventually(func() bool { return b.allow(1) }, time.Second, time.Millisecond).Should(BeTrue())
+	})
+})
+
+var _ = Describe("Test MaxOpsPerSecond", func() {
+	It("drops the mirror requests over the limit before queueing", func() {
+		stats := newFakeStatsClient()
+		config := &ClientConfig{Addrs: []string{"load-test:6379"}, ClientMode: ModeSingleHost, MaxOpsPerSecond: 0.001, MaxOpsBurst: 3}
+		loadTest := &clientImpl{config: config, opsLimiter: newTokenBucket(config.MaxOpsPerSecond, config.MaxOpsBurst)}
+		c := &connectorImpl{
+			loadTestScheduler: newScheduler(&schedulerOptions{maxChanSize: 100}),
+			stats:             stats,
+			logger:            NewNoopLogger(),
+		}
+		r := &routing{client: &clientImpl{config: &ClientConfig{}}, loadTestClients: []*clientImpl{loadTest}}
+
+		c.queueLoadTest(r, newPipelineRequest([][]interface{}{{"SET", "a", "1"}, {"SET", "b", "2"}}))
+		for i := 0; i < 3; i++ {
+			c.queueLoadTest(r, newDoRequest("SET", []interface{}{"k", i}))
+		}
+		Expect(c.loadTestScheduler.backlog()).To(Equal(2))
+		Expect(stats.count(metricDropped, tagFunctionRateLimit)).To(Equal(2))
+	})
+
+	It("drops the shadow reads over the limit", func() {
+		stats := newFakeStatsClient()
+		config := &ClientConfig{Addrs: []string{"load-test:6379"}, ClientMode: ModeSingleHost, MaxOpsPerSecond: 0.001, MaxOpsBurst: 2}
+		loadTest := &clientImpl{config: config, opsLimiter: newTokenBucket(config.MaxOpsPerSecond, config.MaxOpsBurst)}
+		c := &connectorImpl{
+			loadTestScheduler: newScheduler(&schedulerOptions{maxChanSize: 100}),
+			stats:             stats,
+			logger:            NewNoopLogger(),
+		}
+		r := &routing{client: &clientImpl{config: &ClientConfig{}}, loadTestClients: []*clientImpl{loadTest}}
+
+		for i := 0; i < 3; i++ {
+			c.queueShadowRead(r, "GET", []interface{}{"k"}, "v")
+		}
+		Expect(c.loadTestScheduler.backlog()).To(Equal(2))
+		Expect(stats.count(metricDropped, tagFunctionRateLimit)).To(Equal(1))
+	})
+})
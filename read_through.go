@@ -0,0 +1,216 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"context"
+	"strings"
+
+	"github.com/grab/grab-redis/redisapi"
+)
+
+// isReadThrough returns whether the reads missed in the new cluster are read from the old cluster
+func (r *routing) isReadThrough() bool {
+	return r.phase == PhaseReadThrough
+}
+
+// readThrough reads the cmd missed in the new cluster from the old cluster if its key doesn't exist in the new cluster,
+// and copies the keys found to the new cluster in the background. The miss is returned if the old cluster fails, as it is
+// only a warm up of the new cluster.
+func (c *connectorImpl) readThrough(ctx context.Context, r *routing, cmdName string, args []interface{}) (interface{}, error) {
+	if !c.missingKeys(ctx, r, newDoRequest(cmdName, args).cmds)[0] {
+		return nil, nil
+	}
+	value, err := r.client.Do(ctx, cmdName, args...)
+	if err != nil {
+		c.stats.Count1(pkgName, metricError, r.client.getTags(tagFunctionReadThrough, tagCmdPrefix+cmdName))
+		c.logger.Warn(pkgName, "unable to read through %s from main client, Error: %s", cmdName, err)
+		return nil, nil
+	}
+	if value == nil {
+		c.stats.Count1(pkgName, metricMiss, r.client.getTags(tagFunctionReadThrough, tagCmdPrefix+cmdName))
+		return nil, nil
+	}
+	c.stats.Count1(pkgName, metricHit, r.client.getTags(tagFunctionReadThrough, tagCmdPrefix+cmdName))
+	c.queueCopy(r, cmdName, args)
+	return value, nil
+}
+
+// readThroughPipeline reads the cmds of a read-only pipeline missed in the new cluster from the old cluster in one
+// pipeline, the replies found replace the misses and their keys are copied like readThrough
+func (c *connectorImpl) readThroughPipeline(ctx context.Context, r *routing, argsList [][]interface{}, replies []redisapi.ReplyPair) []redisapi.ReplyPair {
+	var nils [][]interface{}
+	var nilIndexes []int
+	for i, reply := range replies {
+		if reply.Err == nil && reply.Value == nil && len(argsList[i]) > 0 {
+			nils = append(nils, argsList[i])
+			nilIndexes = append(nilIndexes, i)
+		}
+	}
+	if len(nils) == 0 {
+		return replies
+	}
+
+	var missed [][]interface{}
+	var indexes []int
+	for i, missing := range c.missingKeys(ctx, r, nils) {
+		if missing {
+			missed = append(missed, nils[i])
+			indexes = append(indexes, nilIndexes[i])
+		}
+	}
+	if len(missed) == 0 {
+		return replies
+	}
+
+	oldReplies, err := r.client.Pipeline(ctx, missed)
+	if len(oldReplies) != len(missed) {
+		c.stats.Count1(pkgName, metricError, r.client.getTags(tagFunctionReadThrough))
+		c.logger.Warn(pkgName, "unable to read through pipeline from main client, Error: %s", err)
+		return replies
+	}
+	for i, reply := range oldReplies {
+		cmdName := cmdNameOf(missed[i])
+		switch {
+		case reply.Err != nil:
+			c.stats.Count1(pkgName, metricError, r.client.getTags(tagFunctionReadThrough, tagCmdPrefix+cmdName))
+		case reply.Value == nil:
+			c.stats.Count1(pkgName, metricMiss, r.client.getTags(tagFunctionReadThrough, tagCmdPrefix+cmdName))
+		default:
+			c.stats.Count1(pkgName, metricHit, r.client.getTags(tagFunctionReadThrough, tagCmdPrefix+cmdName))
+			replies[indexes[i]].Value = reply.Value
+			c.queueCopy(r, cmdName, missed[i][1:])
+		}
+	}
+	return replies
+}
+
+// missingKeys returns whether the first key of each cmd doesn't exist in the new cluster, only the nil replies of the
+// missing keys are read through, e.g. not HGET of a missing field of a hash in the new cluster. The cmds without key and
+// the keys failed to be checked are not missing.
+func (c *connectorImpl) missingKeys(ctx context.Context, r *routing, cmds [][]interface{}) []bool {
+	missing := make([]bool, len(cmds))
+	var exists [][]interface{}
+	var indexes []int
+	for i, cmd := range cmds {
+		if key, ok := r.client.firstKey(cmd); ok {
+			exists = append(exists, []interface{}{"EXISTS", key})
+			indexes = append(indexes, i)
+		}
+	}
+	if len(exists) == 0 {
+		return missing
+	}
+
+	replies, _ := r.readClient().Pipeline(ctx, exists)
+	for i, reply := range replies {
+		if i < len(indexes) {
+			missing[indexes[i]] = reply.Err == nil && reply.Value == int64(0)
+		}
+	}
+	return missing
+}
+
+// queueCopy copies the keys of the cmd found in the old cluster to the new cluster in the background
+func (c *connectorImpl) queueCopy(r *routing, cmdName string, args []interface{}) {
+	req := newDoRequest(cmdName, args)
+	req.readonly = true
+	var keys []string
+	for _, pos := range r.client.keyPositions(req.cmds[0]) {
+		keys = append(keys, argToString(req.cmds[0][pos]))
+	}
+	if len(keys) > 0 && c.allowOps(r.readClient(), len(keys)) {
+		c.queue(r, r.readClient(), func(ctx context.Context, client *clientImpl) error {
+			for _, key := range keys {
+				if err := c.copyKey(ctx, client, key); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, req)
+	}
+}
+
+// copyKey copies the key from the main client to the client with DUMP/RESTORE, keeping the remaining TTL.
+// The key written to the client in the meantime is kept.
+func (c *connectorImpl) copyKey(ctx context.Context, client *clientImpl, key string) error {
+	replies, err := c.mainClient().Pipeline(ctx, [][]interface{}{{"DUMP", key}, {"PTTL", key}})
+	if err != nil {
+		c.stats.Count1(pkgName, metricFailed, client.getTags(tagFunctionReadThrough))
+		c.logger.Warn(pkgName, "read-through DUMP of key %s failed, Error: %s", key, err)
+		return err
+	}
+
+	dump := replies[0].Value
+	ttl, _ := replies[1].Value.(int64)
+	// the key is deleted or expired after the read
+	if dump == nil || ttl == -2 {
+		c.stats.Count1(pkgName, metricSkipped, client.getTags(tagFunctionReadThrough))
+		return nil
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	_, err = client.Do(ctx, "RESTORE", key, ttl, dump)
+	switch {
+	case err == nil:
+		c.stats.Count1(pkgName, metricCopied, client.getTags(tagFunctionReadThrough))
+		return nil
+	case strings.HasPrefix(err.Error(), redisErrBusyKey):
+		c.stats.Count1(pkgName, metricSkipped, client.getTags(tagFunctionReadThrough))
+		return nil
+	default:
+		c.stats.Count1(pkgName, metricFailed, client.getTags(tagFunctionReadThrough))
+		c.logger.Warn(pkgName, "read-through RESTORE of key %s failed, Error: %s", key, err)
+		return err
+	}
+}
+
+// deleteOld deletes the keys written by the request from the main client in PhaseReadThrough before they are written to
+// the new cluster, so the keys deleted, expired or changed in the new cluster are not read through again. A write to a key
+// only in the old cluster starts from an empty key, e.g. INCR returns 1, like a cache miss.
+func (c *connectorImpl) deleteOld(ctx context.Context, r *routing, req *loadTestRequest) error {
+	var deletes [][]interface{}
+	for _, cmd := range req.cmds {
+		for _, pos := range r.client.keyPositions(cmd) {
+			deletes = append(deletes, []interface{}{"DEL", cmd[pos]})
+		}
+	}
+	if req.script != nil {
+		for i := 0; i < req.script.KeyCount() && i < len(req.keysAndArgs); i++ {
+			deletes = append(deletes, []interface{}{"DEL", req.keysAndArgs[i]})
+		}
+	}
+	if len(deletes) == 0 {
+		return nil
+	}
+
+	// one DEL per key, as the keys may be in different slots
+	_, err := r.client.Pipeline(ctx, deletes)
+	return err
+}
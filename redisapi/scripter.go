@@ -38,6 +38,7 @@ type Script struct {
 	keyCount int
 	src      string
 	hash     string
+	readOnly bool
 }
 
 // NewScript returns a new script object. If keyCount is greater than or equal to zero, then the count is
@@ -47,7 +48,15 @@ type Script struct {
 func NewScript(keyCount int, scriptSource string) *Script {
 	h := sha1.New()
 	_, _ = io.WriteString(h, scriptSource)
-	return &Script{keyCount, scriptSource, hex.EncodeToString(h.Sum(nil))}
+	return &Script{keyCount: keyCount, src: scriptSource, hash: hex.EncodeToString(h.Sum(nil))}
+}
+
+// NewReadOnlyScript returns a new script object which only reads, it is not sent to the load test clients when
+// IgnoreReadOnly is enabled.
+func NewReadOnlyScript(keyCount int, scriptSource string) *Script {
+	s := NewScript(keyCount, scriptSource)
+	s.readOnly = true
+	return s
 }
 
 func (s *Script) args(spec string, keysAndArgs []interface{}) []interface{} {
@@ -65,6 +74,21 @@ func (s *Script) args(spec string, keysAndArgs []interface{}) []interface{} {
 	return args
 }
 
+// KeyCount returns the number of keys of the script, it is negative if the keys are passed with the args.
+func (s *Script) KeyCount() int {
+	return s.keyCount
+}
+
+// ReadOnly returns whether the script is created by NewReadOnlyScript.
+func (s *Script) ReadOnly() bool {
+	return s.readOnly
+}
+
+// Source returns the source of the script.
+func (s *Script) Source() string {
+	return s.src
+}
+
 // GetHashAndArgs will return the args for running the script (via EVALSHA)
 func (s *Script) GetHashAndArgs(keysAndArgs ...interface{}) []interface{} {
 	return s.args(s.hash, keysAndArgs)
@@ -0,0 +1,187 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// Replayer plays the cmds captured by the connector against the target, at the captured timing or scaled speed
+type Replayer struct {
+	config *ReplayConfig
+	target *clientImpl
+	// execute sends a replayed request, it is the target by default
+	execute func(ctx context.Context, req *loadTestRequest) error
+
+	sent    *atomic.Int64
+	failed  *atomic.Int64
+	skipped *atomic.Int64
+}
+
+// ReplayProgress is the number of cmds processed by the Replayer
+type ReplayProgress struct {
+	Sent    int64
+	Failed  int64
+	Skipped int64
+}
+
+// NewReplayer creates the target client of the replay
+func NewReplayer(ctx context.Context, config *ReplayConfig, options ...ClientOption) (*Replayer, error) {
+	config.init()
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	target, err := newClient(ctx, config.Target, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	r := newReplayer(config)
+	r.target = target
+	r.execute = func(ctx context.Context, req *loadTestRequest) error {
+		defer target.stats.Duration(pkgName, metricElapsed, time.Now(), target.getTags(tagFunctionReplay)...)
+		return req.execute(ctx, target)
+	}
+	return r, nil
+}
+
+func newReplayer(config *ReplayConfig) *Replayer {
+	return &Replayer{
+		config:  config,
+		sent:    atomic.NewInt64(0),
+		failed:  atomic.NewInt64(0),
+		skipped: atomic.NewInt64(0),
+	}
+}
+
+// Run plays the rotated capture files from the oldest and then the capture file, it returns when all the cmds are done.
+// The time between the cmds is kept across the files.
+func (r *Replayer) Run(ctx context.Context) error {
+	var readers []io.Reader
+	for _, path := range captureFiles(r.config.CaptureFile) {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		readers = append(readers, file)
+	}
+
+	return r.play(ctx, io.MultiReader(readers...))
+}
+
+// Progress returns the number of cmds processed so far
+func (r *Replayer) Progress() ReplayProgress {
+	return ReplayProgress{
+		Sent:    r.sent.Load(),
+		Failed:  r.failed.Load(),
+		Skipped: r.skipped.Load(),
+	}
+}
+
+// ShutDown closes the target client
+func (r *Replayer) ShutDown(ctx context.Context) {
+	r.target.ShutDown(ctx)
+}
+
+func (r *Replayer) play(ctx context.Context, reader io.Reader) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	inFlight := make(chan struct{}, r.config.Concurrency)
+	start := time.Now()
+	var first int64
+	buffered := bufio.NewReader(reader)
+	for {
+		line, err := buffered.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		record := &captureRecord{}
+		if err := json.Unmarshal(line, record); err != nil {
+			// skip the broken record, e.g. a partial line written before a crash
+			r.skipped.Inc()
+			continue
+		}
+
+		if !r.config.Unpaced {
+			if first == 0 {
+				first = record.Time
+			}
+			if err := sleepUntil(ctx, start.Add(time.Duration(float64(record.Time-first)/r.config.Speed))); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		wg.Add(1)
+		go func(req *loadTestRequest) {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+
+			r.sent.Inc()
+			if err := r.execute(ctx, req); err != nil {
+				r.failed.Inc()
+			}
+		}(record.request())
+	}
+}
+
+// sleepUntil waits until the time or the ctx is done
+func sleepUntil(ctx context.Context, t time.Time) error {
+	wait := time.Until(t)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -29,6 +29,7 @@ package redis
 import (
 	"context"
 	"math"
+	"runtime/debug"
 	"sync"
 	"time"
 
@@ -39,6 +40,14 @@ type schedulerOptions struct {
 	maxChanSize       int
 	maxWorker         int
 	workerIdleTimeout time.Duration
+	// orderedByKey runs one worker per lane, the tasks of the same key always go to the same lane
+	orderedByKey bool
+	// latencyPercentile is the percentile (0 to 1) of the execution latency used for scaling, the average is used if it is 0
+	latencyPercentile float64
+	// minWorker workers are kept even if they are idle
+	minWorker int
+	// scaleCooldown is the min time between a scaling and a scale down
+	scaleCooldown time.Duration
 }
 
 func (s *schedulerOptions) normalise() {
@@ -53,36 +62,111 @@ func (s *schedulerOptions) normalise() {
 	if s.workerIdleTimeout <= 0 {
 		s.workerIdleTimeout = defaultWorkerIdleTimeout
 	}
+
+	if s.minWorker < 0 {
+		s.minWorker = 0
+	}
+
+	if s.minWorker > s.maxWorker {
+		s.minWorker = s.maxWorker
+	}
+
+	if s.scaleCooldown <= 0 {
+		s.scaleCooldown = parseDurationInMs(defaultSchedulerScaleCooldownInMs)
+	}
+}
+
+func newSchedulerOptions(config *ConnectorConfig) *schedulerOptions {
+	return &schedulerOptions{
+		maxChanSize:       config.SchedulerChannelSize,
+		maxWorker:         config.SchedulerWorkerNumber,
+		workerIdleTimeout: parseDurationInMs(config.SchedulerWorkerIdleTimeoutInMs),
+		orderedByKey:      config.SchedulerOrderedByKey,
+		latencyPercentile: config.SchedulerLatencyPercentile,
+		minWorker:         config.SchedulerMinWorkerNumber,
+		scaleCooldown:     parseDurationInMs(config.SchedulerScaleCooldownInMs),
+	}
 }
 
+// panicHandler is called with the recovered value and the stack trace when a task panics
+type panicHandler func(recovered interface{}, stack []byte)
+
 type scheduler struct {
+	// mu guards the channels and the options, which are swapped in resizing.
+	// The tasks are sent with the read lock, so no task is sent to an old channel after it is swapped.
+	mu                sync.RWMutex
 	fnChan            chan func(ctx context.Context)
+	lanes             []chan func(ctx context.Context)
+	changed           chan struct{}
+	nextLane          *atomic.Uint64
 	maxWorker         int
+	minWorker         int
 	workerIdleTimeout time.Duration
+	latencyPercentile float64
+	scaleCooldown     time.Duration
+	// stop asks an idle worker to exit in scaling down
+	stop chan struct{}
 
 	latencies *latencies
 	numWorker *atomic.Int64
+	// running counts the tasks being executed
+	running *atomic.Int64
+	// executed counts the tasks executed since the last scaling
+	executed *atomic.Int64
+	// onPanic is called when a task panics, the worker is replaced after that
+	onPanic panicHandler
 
 	wg *sync.WaitGroup
+	// laneWg waits for the workers of the current lanes
+	laneWg *sync.WaitGroup
+	// lanesStarted is set once the workers of the lanes are started, by start or resize
+	lanesStarted bool
+}
+
+// current returns the channel for the workers, and the channel closed when the channel is swapped
+func (s *scheduler) current() (chan func(ctx context.Context), chan struct{}, time.Duration, int64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.fnChan, s.changed, s.workerIdleTimeout, int64(s.minWorker)
 }
 
 func (s *scheduler) spawnWorker(ctx context.Context) {
 	s.numWorker.Inc()
-	defer s.numWorker.Dec()
+	// the idle worker has already decremented the number of workers when it exits
+	idle := false
+	defer func() {
+		if !idle {
+			s.numWorker.Dec()
+		}
+	}()
 	defer s.wg.Done()
-	idleTimeoutTicker := time.NewTicker(s.workerIdleTimeout)
+	fnChan, changed, workerIdleTimeout, minWorker := s.current()
+	idleTimeoutTicker := time.NewTicker(workerIdleTimeout)
 	defer idleTimeoutTicker.Stop()
 
 	for {
 		select {
-		case fn := <-s.fnChan:
-			start := time.Now()
-			fn(ctx)
-			elapsed := time.Since(start)
-			s.latencies.Add(elapsed.Nanoseconds())
+		case fn, ok := <-fnChan:
+			if !ok {
+				return
+			}
+			if !s.execute(ctx, fn) {
+				// replace the worker with a new one, nothing of the panicked task is left in the new goroutine
+				s.wg.Add(1)
+				go s.spawnWorker(ctx)
+				return
+			}
 
-			idleTimeoutTicker.Reset(s.workerIdleTimeout)
+			idleTimeoutTicker.Reset(workerIdleTimeout)
+		case <-changed:
+			fnChan, changed, workerIdleTimeout, minWorker = s.current()
+			idleTimeoutTicker.Reset(workerIdleTimeout)
 		case <-idleTimeoutTicker.C:
+			// the min workers are kept for the next burst
+			if idle = s.retireIdle(minWorker); idle {
+				return
+			}
+		case <-s.stop:
 			return
 		case <-ctx.Done():
 			return
@@ -90,44 +174,396 @@ func (s *scheduler) spawnWorker(ctx context.Context) {
 	}
 }
 
-func (s *scheduler) start(ctx context.Context) {
-	backlogTicker := time.NewTicker(100 * time.Millisecond)
-	defer backlogTicker.Stop()
-
+// retireIdle decrements the number of workers if it's above minWorker, it returns whether the idle worker should exit.
+// The check and the decrement are done by one CompareAndSwap, so the workers idle at the same time don't go below min.
+func (s *scheduler) retireIdle(minWorker int64) bool {
 	for {
-		select {
-		case <-backlogTicker.C:
-			backlog := len(s.fnChan)
-			if backlog == 0 {
-				continue
+		n := s.numWorker.Load()
+		if n <= minWorker {
+			return false
+		}
+		if s.numWorker.CompareAndSwap(n, n-1) {
+			return true
+		}
+	}
+}
+
+// execute runs the task and records its latency, it returns false if the task panics
+func (s *scheduler) execute(ctx context.Context, fn func(ctx context.Context)) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+			if s.onPanic != nil {
+				s.onPanic(r, debug.Stack())
 			}
+		}
+	}()
+
+	s.running.Inc()
+	defer s.running.Dec()
+
+	start := time.Now()
+	fn(ctx)
+	s.latencies.Add(time.Since(start).Nanoseconds())
+	s.executed.Inc()
+	return true
+}
+
+// channel returns the channel to send the task to, in the ordered mode the tasks of the same key go to the same lane,
+// and the tasks without key are spread over the lanes. It should be called with the lock held.
+func (s *scheduler) channel(key string, hasKey bool) chan func(ctx context.Context) {
+	if len(s.lanes) == 0 {
+		return s.fnChan
+	}
+
+	var index uint64
+	if hasKey {
+		// mix the hash, so the lanes are not correlated with the key hash sampling which uses the low bits
+		index = (keyHash(key) * laneHashMultiplier) >> 32
+	} else {
+		index = s.nextLane.Inc()
+	}
+	return s.lanes[index%uint64(len(s.lanes))]
+}
+
+// send sends the task to the channel of the key, it waits for the room of the channel if block is true,
+// otherwise it returns false if the channel is full. The lock is released while waiting, so resizing and scaling are not
+// blocked by a full channel.
+func (s *scheduler) send(key string, hasKey bool, task func(ctx context.Context), block bool) bool {
+	for {
+		if s.trySend(key, hasKey, task) {
+			return true
+		}
+		if !block {
+			return false
+		}
+		time.Sleep(schedulerSendRetryInterval)
+	}
+}
+
+// trySend sends the task to the channel of the key holding the read lock, so no task is sent to a swapped channel
+func (s *scheduler) trySend(key string, hasKey bool, task func(ctx context.Context)) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-			need := int64(1)
-			avgLatency := s.latencies.Average() // nanoseconds
-			if avgLatency > 0 {
-				need = int64(math.Ceil(float64(backlog) * (avgLatency / 1e9)))
+	select {
+	case s.channel(key, hasKey) <- task:
+		return true
+	default:
+		return false
+	}
+}
+
+// ordered returns whether the tasks of the same key are sent to the same lane
+func (s *scheduler) ordered() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.lanes) > 0
+}
+
+// backlog returns the number of tasks waiting in the channels
+func (s *scheduler) backlog() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.lanes) == 0 {
+		return len(s.fnChan)
+	}
+
+	backlog := 0
+	for _, lane := range s.lanes {
+		backlog += len(lane)
+	}
+	return backlog
+}
+
+// idle returns whether no task is queued or being executed
+func (s *scheduler) idle() bool {
+	return s.backlog() == 0 && s.running.Load() == 0
+}
+
+// capacity returns the total size of the channels
+func (s *scheduler) capacity() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.lanes) == 0 {
+		return cap(s.fnChan)
+	}
+	return cap(s.lanes[0]) * len(s.lanes)
+}
+
+// resize swaps in the channels of the new size, and moves the queued tasks to them.
+// In the ordered mode, the new lanes are started after the old lanes are drained, to keep the order of the tasks of a key.
+func (s *scheduler) resize(ctx context.Context, options *schedulerOptions) {
+	options.normalise()
+
+	s.mu.Lock()
+	// the channels are closed once the scheduler is stopped
+	if ctx.Err() != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.maxWorker = options.maxWorker
+	s.workerIdleTimeout = options.workerIdleTimeout
+	s.latencyPercentile = options.latencyPercentile
+	s.minWorker = options.minWorker
+	s.scaleCooldown = options.scaleCooldown
+
+	if len(s.lanes) == options.maxWorker && cap(s.lanes[0]) == laneSize(options) {
+		s.mu.Unlock()
+		return
+	}
+
+	if len(s.lanes) > 0 {
+		oldLanes, oldLaneWg := s.lanes, s.laneWg
+		if !s.lanesStarted {
+			// the old lanes need the workers to be drained, the new lanes are started after that
+			s.lanesStarted = true
+			s.startLanes(ctx, oldLanes, oldLaneWg)
+		}
+		lanes, laneWg := newLanes(options), &sync.WaitGroup{}
+		s.lanes, s.laneWg = lanes, laneWg
+		// added with the lock held, so start waits for it before closing the lanes
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		// nothing is sent to the old lanes after the swap, the workers of the old lanes exit once they are drained
+		for _, lane := range oldLanes {
+			close(lane)
+		}
+		go func() {
+			defer s.wg.Done()
+			oldLaneWg.Wait()
+			s.startLanes(ctx, lanes, laneWg)
+		}()
+		return
+	}
+
+	oldChan, oldChanged := s.fnChan, s.changed
+	if cap(oldChan) != options.maxChanSize {
+		s.fnChan = make(chan func(ctx context.Context), options.maxChanSize)
+	}
+	s.changed = make(chan struct{})
+	newChan := s.fnChan
+	if oldChan != newChan {
+		// the drainer is added with the lock held, so start waits for it before closing the channel
+		s.wg.Add(1)
+	}
+	s.mu.Unlock()
+
+	// wake up the idle workers to pick up the new channel and options
+	close(oldChanged)
+	if oldChan == newChan {
+		return
+	}
+
+	go func() {
+		defer s.wg.Done()
+		for {
+			select {
+			case fn := <-oldChan:
+				select {
+				case newChan <- fn:
+				case <-ctx.Done():
+					return
+				}
+			default:
+				return
 			}
+		}
+	}()
+}
 
-			for i := int64(0); i < need && s.numWorker.Load() < int64(s.maxWorker); i++ {
+// runLane executes the tasks of a lane one by one, so the order of the tasks of a key is kept
+func (s *scheduler) runLane(ctx context.Context, lane chan func(ctx context.Context), laneWg *sync.WaitGroup) {
+	s.numWorker.Inc()
+	defer s.numWorker.Dec()
+	defer s.wg.Done()
+	defer laneWg.Done()
+
+	for {
+		select {
+		case fn, ok := <-lane:
+			if !ok {
+				return
+			}
+			if !s.execute(ctx, fn) {
 				s.wg.Add(1)
-				go s.spawnWorker(ctx)
+				laneWg.Add(1)
+				go s.runLane(ctx, lane, laneWg)
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *scheduler) startLanes(ctx context.Context, lanes []chan func(ctx context.Context), laneWg *sync.WaitGroup) {
+	if ctx.Err() != nil {
+		return
+	}
+	for _, lane := range lanes {
+		s.wg.Add(1)
+		laneWg.Add(1)
+		go s.runLane(ctx, lane, laneWg)
+	}
+}
+
+func (s *scheduler) start(ctx context.Context) {
+	s.mu.Lock()
+	ordered := len(s.lanes) > 0
+	if ordered && !s.lanesStarted {
+		s.lanesStarted = true
+		s.startLanes(ctx, s.lanes, s.laneWg)
+	}
+	s.mu.Unlock()
+
+	if ordered {
+		<-ctx.Done()
+		s.wg.Wait()
+		s.mu.Lock()
+		for _, lane := range s.lanes {
+			close(lane)
+		}
+		s.mu.Unlock()
+		return
+	}
+
+	backlogTicker := time.NewTicker(schedulerScaleInterval)
+	defer backlogTicker.Stop()
+
+	lastBacklog := 0
+	var lastScale time.Time
+	for {
+		select {
+		case now := <-backlogTicker.C:
+			backlog := s.backlog()
+			desired := s.desiredWorkers(backlog, backlog-lastBacklog, s.executed.Swap(0), s.latency())
+			lastBacklog = backlog
+
+			current := s.numWorker.Load()
+			switch {
+			case desired > current:
+				for i := current; i < desired; i++ {
+					s.wg.Add(1)
+					go s.spawnWorker(ctx)
+				}
+				lastScale = now
+			case desired < current && now.Sub(lastScale) >= s.cooldown():
+				s.stopWorkers(current - desired)
+				lastScale = now
 			}
 		case <-ctx.Done():
 			s.wg.Wait()
+			s.mu.Lock()
 			close(s.fnChan)
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// desiredWorkers returns the number of workers to keep up with the tasks, by Little's law it is the arrival rate times the
+// latency. The arrival rate is the executed tasks plus the growth of the backlog, plus the backlog to be drained in a second.
+func (s *scheduler) desiredWorkers(backlog int, growth int, executed int64, latency float64) int64 {
+	s.mu.RLock()
+	minWorker, maxWorker := int64(s.minWorker), int64(s.maxWorker)
+	s.mu.RUnlock()
+
+	var desired int64
+	if backlog > 0 || executed > 0 {
+		desired = 1
+	}
+	if latency > 0 {
+		ticksPerSecond := float64(time.Second / schedulerScaleInterval)
+		arrivalRate := float64(executed+int64(growth))*ticksPerSecond + float64(backlog)
+		if arrivalRate > 0 {
+			desired = int64(math.Ceil(arrivalRate * latency / 1e9))
+		}
+	}
+
+	if desired < minWorker {
+		desired = minWorker
+	}
+	if desired > maxWorker {
+		desired = maxWorker
+	}
+	return desired
+}
+
+// stopWorkers stops at most n idle workers, the busy ones are left to the next scaling
+func (s *scheduler) stopWorkers(n int64) {
+	for i := int64(0); i < n; i++ {
+		select {
+		case s.stop <- struct{}{}:
+		default:
 			return
 		}
 	}
 }
 
+func (s *scheduler) cooldown() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.scaleCooldown
+}
+
+// latency returns the execution latency for scaling in nanoseconds, it is the configured percentile or the average
+func (s *scheduler) latency() float64 {
+	s.mu.RLock()
+	percentile := s.latencyPercentile
+	s.mu.RUnlock()
+
+	if percentile > 0 {
+		return s.latencies.Percentile(percentile)
+	}
+	return s.latencies.Average()
+}
+
 func newScheduler(options *schedulerOptions) *scheduler {
 	options.normalise()
-	return &scheduler{
-		fnChan:            make(chan func(context.Context), options.maxChanSize),
+	s := &scheduler{
+		nextLane:          atomic.NewUint64(0),
+		changed:           make(chan struct{}),
 		maxWorker:         options.maxWorker,
 		workerIdleTimeout: options.workerIdleTimeout,
+		latencyPercentile: options.latencyPercentile,
+		minWorker:         options.minWorker,
+		scaleCooldown:     options.scaleCooldown,
+		stop:              make(chan struct{}),
+		executed:          atomic.NewInt64(0),
 		latencies:         newLatencies(1000),
 		numWorker:         atomic.NewInt64(int64(0)),
+		running:           atomic.NewInt64(0),
 		wg:                &sync.WaitGroup{},
+		laneWg:            &sync.WaitGroup{},
+	}
+
+	if !options.orderedByKey {
+		s.fnChan = make(chan func(context.Context), options.maxChanSize)
+		return s
+	}
+
+	s.lanes = newLanes(options)
+	return s
+}
+
+// newLanes creates a lane for each worker
+func newLanes(options *schedulerOptions) []chan func(context.Context) {
+	lanes := make([]chan func(context.Context), options.maxWorker)
+	for i := range lanes {
+		lanes[i] = make(chan func(context.Context), laneSize(options))
+	}
+	return lanes
+}
+
+// laneSize returns the channel size of a lane, the channel size is shared by the lanes
+func laneSize(options *schedulerOptions) int {
+	size := options.maxChanSize / options.maxWorker
+	if size < 1 {
+		size = 1
 	}
+	return size
 }
@@ -0,0 +1,281 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.uber.org/atomic"
+)
+
+var _ = Describe("Test Scheduler ordered by key", func() {
+	It("keeps the order of the tasks of the same key", func() {
+		s := newScheduler(&schedulerOptions{maxChanSize: 1000, maxWorker: 4, workerIdleTimeout: time.Second, orderedByKey: true})
+		Expect(s.lanes).To(HaveLen(4))
+		Expect(s.capacity()).To(Equal(1000))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go s.start(ctx)
+		defer cancel()
+
+		var mu sync.Mutex
+		executed := make(map[string][]int)
+		for i := 0; i < 100; i++ {
+			for k := 0; k < 5; k++ {
+				key, value := fmt.Sprintf("key-%d", k), i
+				s.channel(key, true) <- func(ctx context.Context) {
+					mu.Lock()
+					defer mu.Unlock()
+					executed[key] = append(executed[key], value)
+				}
+			}
+		}
+
+		Eventually(func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			total := 0
+			for _, values := range executed {
+				total += len(values)
+			}
+			return total
+		}).Should(Equal(500))
+
+		for _, values := range executed {
+			for i, value := range values {
+				Expect(value).To(Equal(i))
+			}
+		}
+	})
+
+	It("sends the keys with the same hash tag to the same lane", func() {
+		s := newScheduler(&schedulerOptions{maxChanSize: 100, maxWorker: 8, orderedByKey: true})
+		for i := 0; i < 100; i++ {
+			tag := fmt.Sprintf("{user-%d}", i)
+			Expect(s.channel(tag+":a", true)).To(Equal(s.channel(tag+":b", true)))
+		}
+	})
+
+	It("uses one channel when it is not ordered", func() {
+		s := newScheduler(&schedulerOptions{maxChanSize: 100, maxWorker: 8})
+		Expect(s.lanes).To(BeEmpty())
+		Expect(s.channel("a", true)).To(Equal(s.fnChan))
+		Expect(s.channel("", false)).To(Equal(s.fnChan))
+	})
+})
+
+var _ = Describe("Test Scheduler resize", func() {
+	It("moves the queued tasks to the new channel", func() {
+		s := newScheduler(&schedulerOptions{maxChanSize: 10, maxWorker: 1, workerIdleTimeout: time.Second})
+		var executed atomic.Int64
+		for i := 0; i < 10; i++ {
+			Expect(s.send("", false, func(ctx context.Context) { executed.Inc() }, false)).To(BeTrue())
+		}
+		Expect(s.send("", false, func(ctx context.Context) {}, false)).To(BeFalse())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s.resize(ctx, &schedulerOptions{maxChanSize: 100, maxWorker: 4, workerIdleTimeout: time.Second})
+		Expect(s.capacity()).To(Equal(100))
+		Expect(s.maxWorker).To(Equal(4))
+
+		go s.start(ctx)
+		Eventually(executed.Load).Should(Equal(int64(10)))
+	})
+
+	It("keeps the order of the tasks of the same key when the lanes are resized", func() {
+		s := newScheduler(&schedulerOptions{maxChanSize: 1000, maxWorker: 2, workerIdleTimeout: time.Second, orderedByKey: true})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go s.start(ctx)
+
+		var mu sync.Mutex
+		var executed []int
+		for i := 0; i < 200; i++ {
+			if i == 100 {
+				s.resize(ctx, &schedulerOptions{maxChanSize: 1000, maxWorker: 8, workerIdleTimeout: time.Second, orderedByKey: true})
+			}
+			value := i
+			Expect(s.send("key", true, func(ctx context.Context) {
+				mu.Lock()
+				defer mu.Unlock()
+				executed = append(executed, value)
+			}, true)).To(BeTrue())
+		}
+
+		Eventually(func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(executed)
+		}).Should(Equal(200))
+		for i, value := range executed {
+			Expect(value).To(Equal(i))
+		}
+		Expect(s.lanes).To(HaveLen(8))
+	})
+})
+
+var _ = Describe("Test Scheduler blocking send", func() {
+	It("doesn't hold the lock while waiting for the room of a full channel", func() {
+		s := newScheduler(&schedulerOptions{maxChanSize: 1, maxWorker: 1, workerIdleTimeout: time.Second})
+		Expect(s.send("", false, func(ctx context.Context) {}, false)).To(BeTrue())
+
+		sent := make(chan bool)
+		go func() {
+			sent <- s.send("", false, func(ctx context.Context) {}, true)
+		}()
+		Consistently(sent, 50*time.Millisecond).ShouldNot(Receive())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		resized := make(chan struct{})
+		go func() {
+			s.resize(ctx, &schedulerOptions{maxChanSize: 10, maxWorker: 1, workerIdleTimeout: time.Second})
+			close(resized)
+		}()
+		Eventually(resized).Should(BeClosed())
+		Eventually(sent).Should(Receive(BeTrue()))
+		Eventually(s.backlog).Should(Equal(2))
+	})
+})
+
+var _ = Describe("Test Scheduler metrics", func() {
+	It("reports the scheduler gauges and the enqueue to execute lag", func() {
+		stats := newFakeStatsClient()
+		loadTest := &clientImpl{config: &ClientConfig{Addrs: []string{"load-test:6379"}, ClientMode: ModeSingleHost}}
+		c := &connectorImpl{
+			loadTestScheduler: newScheduler(&schedulerOptions{maxChanSize: 10, maxWorker: 1, workerIdleTimeout: time.Second}),
+			stats:             stats,
+			logger:            NewNoopLogger(),
+		}
+		c.routing.Store(&routing{client: &clientImpl{config: &ClientConfig{}}})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		for i := 0; i < 3; i++ {
+			c.queue(c.loadRouting(), loadTest, func(ctx context.Context, client *clientImpl) error { return nil }, newDoRequest("PING", nil))
+		}
+		go c.monitorScheduler(ctx, 10*time.Millisecond)
+		Eventually(func() float64 {
+			backlog, _ := stats.gauge("redis.scheduler." + metricBacklog)
+			return backlog
+		}).Should(Equal(float64(3)))
+		capacity, _ := stats.gauge("redis.scheduler." + metricCapacity)
+		Expect(capacity).To(Equal(float64(10)))
+
+		go c.loadTestScheduler.start(ctx)
+		Eventually(func() int { return stats.count(metricLag, tagFunctionScheduler) }).Should(Equal(3))
+		Eventually(func() float64 {
+			backlog, _ := stats.gauge("redis.scheduler." + metricBacklog)
+			return backlog
+		}).Should(BeZero())
+		_, ok := stats.gauge("redis.scheduler." + metricActive)
+		Expect(ok).To(BeTrue())
+	})
+})
+
+var _ = Describe("Test Scheduler scaling", func() {
+	It("decides the workers by the arrival rate and the latency", func() {
+		s := newScheduler(&schedulerOptions{maxChanSize: 100, maxWorker: 20, minWorker: 2})
+		// idle
+		Expect(s.desiredWorkers(0, 0, 0, 0)).To(Equal(int64(2)))
+		// no latency yet
+		Expect(s.desiredWorkers(10, 10, 0, 0)).To(Equal(int64(2)))
+		// 10 tasks per tick of 10ms each: 100 tasks per second, 1 worker
+		Expect(s.desiredWorkers(0, 0, 10, 1e7)).To(Equal(int64(2)))
+		// 100 tasks per tick of 10ms each: 1000 tasks per second, 10 workers
+		Expect(s.desiredWorkers(0, 0, 100, 1e7)).To(Equal(int64(10)))
+		// the growth of the backlog adds the workers
+		Expect(s.desiredWorkers(50, 50, 100, 1e7)).To(Equal(int64(16)))
+		// capped by the max workers
+		Expect(s.desiredWorkers(0, 0, 1000, 1e7)).To(Equal(int64(20)))
+	})
+
+	It("keeps the min workers and stops the idle workers after the cooldown", func() {
+		s := newScheduler(&schedulerOptions{maxChanSize: 1000, maxWorker: 8, minWorker: 2, workerIdleTimeout: time.Hour, scaleCooldown: 100 * time.Millisecond})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go s.start(ctx)
+		Eventually(s.numWorker.Load).Should(Equal(int64(2)))
+
+		for i := 0; i < 1000; i++ {
+			s.send("", false, func(ctx context.Context) { time.Sleep(time.Millisecond) }, true)
+		}
+		Eventually(s.numWorker.Load).Should(BeNumerically(">", 2))
+		Eventually(s.numWorker.Load, 5).Should(Equal(int64(2)))
+	})
+
+	It("doesn't stop the idle workers below the min workers at the same time", func() {
+		s := newScheduler(&schedulerOptions{maxChanSize: 10, maxWorker: 8, minWorker: 2})
+		s.numWorker.Store(3)
+
+		var retired atomic.Int64
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if s.retireIdle(2) {
+					retired.Inc()
+				}
+			}()
+		}
+		wg.Wait()
+		Expect(retired.Load()).To(Equal(int64(1)))
+		Expect(s.numWorker.Load()).To(Equal(int64(2)))
+	})
+})
+
+var _ = Describe("Test Scheduler panic", func() {
+	It("recovers the panicked task and replaces the worker", func() {
+		for _, ordered := range []bool{false, true} {
+			s := newScheduler(&schedulerOptions{maxChanSize: 10, maxWorker: 1, workerIdleTimeout: time.Hour, orderedByKey: ordered})
+			var panics atomic.Int64
+			s.onPanic = func(recovered interface{}, stack []byte) {
+				Expect(recovered).To(Equal("boom"))
+				Expect(string(stack)).To(ContainSubstring("scheduler_test.go"))
+				panics.Inc()
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			go s.start(ctx)
+
+			var executed atomic.Int64
+			s.send("key", true, func(ctx context.Context) { panic("boom") }, true)
+			s.send("key", true, func(ctx context.Context) { executed.Inc() }, true)
+
+			Eventually(executed.Load).Should(Equal(int64(1)))
+			Expect(panics.Load()).To(Equal(int64(1)))
+			Expect(s.numWorker.Load()).To(Equal(int64(1)))
+			cancel()
+		}
+	})
+})
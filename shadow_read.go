@@ -0,0 +1,99 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+)
+
+// queueShadowRead sends the read-only cmd to every load test client through the scheduler and compares the reply with the
+// reply of the main client, the result is reported as match/mismatch metrics tagged by the cmd.
+func (c *connectorImpl) queueShadowRead(r *routing, cmdName string, args []interface{}, mainValue interface{}) {
+	// replies of random cmds like SRANDMEMBER can't be compared
+	if r.client.ifCommandHasFlag(cmdName, redisFlagRandom) {
+		return
+	}
+	unordered := r.client.ifCommandHasFlag(cmdName, redisFlagSortForScript)
+
+	logSampleRate := r.shadowReadLogSampleRate
+	req := newDoRequest(cmdName, args)
+	req.readonly = true
+	for _, client := range r.mirrorClients() {
+		sampled := c.requestFor(r, client, req)
+		if sampled == nil || !c.allowOps(client, sampled.ops()) {
+			continue
+		}
+		c.queue(r, client, func(ctx context.Context, client *clientImpl) error {
+			// the keys may be rewritten for the load test client
+			value, err := client.Do(ctx, cmdName, sampled.cmds[0][1:]...)
+			if err != nil {
+				c.stats.Count1(pkgName, metricError, client.getTags(tagFunctionShadowRead, tagCmdPrefix+cmdName))
+				return err
+			}
+
+			if isReplyEqual(mainValue, value, unordered) {
+				c.stats.Count1(pkgName, metricMatch, client.getTags(tagFunctionShadowRead, tagCmdPrefix+cmdName))
+				return nil
+			}
+
+			c.stats.Count1(pkgName, metricMismatch, client.getTags(tagFunctionShadowRead, tagCmdPrefix+cmdName))
+			if rand.Float64() < logSampleRate {
+				c.logger.Warn(pkgName, "shadow read mismatch on load test client %s, cmd: %s %v, main reply: %v, load test reply: %v", client.config.name(), cmdName, args, mainValue, value)
+			}
+			return nil
+		}, sampled)
+	}
+}
+
+// isReplyEqual compares two replies, the order of the elements is ignored when unordered is true,
+// e.g. SMEMBERS of the same set could return the members in different order from two clusters.
+func isReplyEqual(a, b interface{}, unordered bool) bool {
+	if !unordered {
+		return reflect.DeepEqual(a, b)
+	}
+
+	aValues, aOk := a.([]interface{})
+	bValues, bOk := b.([]interface{})
+	if !aOk || !bOk {
+		return reflect.DeepEqual(a, b)
+	}
+
+	return reflect.DeepEqual(sortedReply(aValues), sortedReply(bValues))
+}
+
+func sortedReply(values []interface{}) []string {
+	result := make([]string, len(values))
+	for i, value := range values {
+		result[i] = fmt.Sprint(value)
+	}
+	sort.Strings(result)
+	return result
+}
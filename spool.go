@@ -0,0 +1,351 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/grab/grab-redis/redisapi"
+)
+
+var errSpoolFull = errors.New("spool is full")
+
+// errSpoolReplayedLate is the error of the dead letters of the replayed writes, which may be older than the live writes of
+// the same keys sent since they were spooled
+var errSpoolReplayedLate = errors.New("replayed from the spool after the newer writes")
+
+// spoolRecord is a load test request serialized in the spool, the args are kept as bytes so binary values survive
+type spoolRecord struct {
+	LoadTest    string     `json:"loadTest"`
+	Function    string     `json:"function"`
+	Cmds        [][][]byte `json:"cmds,omitempty"`
+	Script      string     `json:"script,omitempty"`
+	KeyCount    int        `json:"keyCount,omitempty"`
+	KeysAndArgs [][]byte   `json:"keysAndArgs,omitempty"`
+}
+
+func newSpoolRecord(loadTest string, req *loadTestRequest) *spoolRecord {
+	record := &spoolRecord{
+		LoadTest:    loadTest,
+		Function:    req.function,
+		KeysAndArgs: argsToBytes(req.keysAndArgs),
+	}
+	for _, cmd := range req.cmds {
+		record.Cmds = append(record.Cmds, argsToBytes(cmd))
+	}
+	if req.script != nil {
+		record.Script = req.script.Source()
+		record.KeyCount = req.script.KeyCount()
+	}
+	return record
+}
+
+func (r *spoolRecord) request() *loadTestRequest {
+	req := &loadTestRequest{
+		function:    r.Function,
+		keysAndArgs: bytesToArgs(r.KeysAndArgs),
+	}
+	for _, cmd := range r.Cmds {
+		args := bytesToArgs(cmd)
+		if len(args) > 0 {
+			// the cmd name is expected to be a string
+			args[0] = string(cmd[0])
+		}
+		req.cmds = append(req.cmds, args)
+	}
+	if r.Function == tagFunctionRun {
+		req.script = redisapi.NewScript(r.KeyCount, r.Script)
+	}
+	return req
+}
+
+func argsToBytes(args []interface{}) [][]byte {
+	if args == nil {
+		return nil
+	}
+	result := make([][]byte, len(args))
+	for i, arg := range args {
+		result[i] = []byte(argToString(arg))
+	}
+	return result
+}
+
+func bytesToArgs(values [][]byte) []interface{} {
+	if values == nil {
+		return nil
+	}
+	result := make([]interface{}, len(values))
+	for i, value := range values {
+		result[i] = value
+	}
+	return result
+}
+
+// spool is an append-only file of the overflowed load test requests, one JSON record per line.
+// The records are read from offset, the file is truncated once all the records are replayed, and the replayed records
+// are compacted out when the file is full.
+type spool struct {
+	mu      sync.Mutex
+	file    *os.File
+	maxSize int64
+	size    int64
+	offset  int64
+}
+
+func newSpool(dir string, maxSize int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, spoolFileName), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	// the records left by the last run are replayed as well
+	return &spool{file: file, maxSize: maxSize, size: info.Size()}, nil
+}
+
+func (s *spool) append(record *spoolRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size+int64(len(data)) > s.maxSize && s.offset > 0 {
+		// the replayed records are dropped to make room, the size only counts the pending records after that
+		if err := s.compact(); err != nil {
+			return err
+		}
+	}
+	if s.size+int64(len(data)) > s.maxSize {
+		return errSpoolFull
+	}
+
+	n, err := s.file.WriteAt(data, s.size)
+	s.size += int64(n)
+	return err
+}
+
+// read returns at most n records from the offset and the end offset of each record, the offset is not moved until commit
+func (s *spool) read(n int) ([]*spoolRecord, []int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var records []*spoolRecord
+	var ends []int64
+	offset := s.offset
+	reader := bufio.NewReader(io.NewSectionReader(s.file, s.offset, s.size-s.offset))
+	for len(records) < n {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return records, ends, err
+		}
+		offset += int64(len(line))
+
+		record := &spoolRecord{}
+		if err := json.Unmarshal(line, record); err != nil {
+			// skip the broken record, e.g. a partial line written before a crash
+			continue
+		}
+		records = append(records, record)
+		ends = append(ends, offset)
+	}
+
+	return records, ends, nil
+}
+
+// commit moves the offset to the end of the replayed records, the file is truncated if everything is replayed
+func (s *spool) commit(offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.offset = offset
+	if s.offset < s.size {
+		return nil
+	}
+
+	s.offset, s.size = 0, 0
+	return s.file.Truncate(0)
+}
+
+// compact moves the pending records to the beginning of the file and truncates the replayed ones, it's called with the
+// lock held
+func (s *spool) compact() error {
+	buf := make([]byte, spoolCompactBufferSize)
+	var written int64
+	for from := s.offset; from < s.size; {
+		chunk := buf
+		if remaining := s.size - from; remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+		n, err := s.file.ReadAt(chunk, from)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		if _, err := s.file.WriteAt(buf[:n], written); err != nil {
+			return err
+		}
+		from += int64(n)
+		written += int64(n)
+	}
+
+	s.offset, s.size = 0, written
+	return s.file.Truncate(written)
+}
+
+func (s *spool) pending() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size - s.offset
+}
+
+func (s *spool) close() error {
+	return s.file.Close()
+}
+
+// spoolRequest appends the request dropped by the full queue to the spool, it returns false if the request is not spooled
+func (c *connectorImpl) spoolRequest(client *clientImpl, req *loadTestRequest) bool {
+	// the reads are only for load, they are not worth spooling
+	if c.spool == nil || req.readonly {
+		return false
+	}
+
+	if err := c.spool.append(newSpoolRecord(client.config.name(), req)); err != nil {
+		c.stats.Count1(pkgName, metricDropped, client.getTags(tagFunctionSpool))
+		c.logger.Error(pkgName, "failed to spool load test request, Error: %s", err)
+		return false
+	}
+
+	c.stats.Count1(pkgName, metricSpooled, client.getTags(tagFunctionSpool))
+	return true
+}
+
+// replaySpool sends the spooled requests back to the scheduler once the backlog drains
+func (c *connectorImpl) replaySpool(ctx context.Context) {
+	ticker := time.NewTicker(spoolReplayInterval)
+	defer ticker.Stop()
+	defer func() {
+		if err := c.spool.close(); err != nil {
+			c.logger.Warn(pkgName, "failed to close spool, Error: %s", err)
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.stats.Gauge("redis.spool", metricSize, float64(c.spool.pending()), c.mainClient().getTags(tagFunctionSpool))
+			c.replaySpoolOnce(ctx)
+		}
+	}
+}
+
+func (c *connectorImpl) replaySpoolOnce(ctx context.Context) {
+	r := c.acquire()
+	defer r.release()
+
+	// only replay when the backlog is below half of the queue, to leave room for the live traffic
+	free := c.loadTestScheduler.capacity()/2 - c.loadTestScheduler.backlog()
+	if free <= 0 || c.spool.pending() == 0 {
+		return
+	}
+
+	records, ends, err := c.spool.read(free)
+	if err != nil {
+		c.logger.Warn(pkgName, "failed to read spool, Error: %s", err)
+	}
+
+	clients := make(map[string]*clientImpl)
+	for _, client := range r.mirrorClients() {
+		clients[client.config.name()] = client
+	}
+
+	var committed int64 = -1
+	for i, record := range records {
+		if ctx.Err() != nil {
+			break
+		}
+
+		client, ok := clients[record.LoadTest]
+		if !ok {
+			// the load test client is removed, or mirroring is stopped by the migration phase
+			c.stats.Count1(pkgName, metricDropped, r.client.getTags(tagFunctionSpool))
+			committed = ends[i]
+			continue
+		}
+
+		req := record.request()
+		enqueued := time.Now()
+		task := func(ctx context.Context) {
+			c.stats.Duration(pkgName, metricLag, enqueued, client.getTags(tagFunctionScheduler)...)
+			_ = c.mirror(ctx, client, req)
+		}
+		key, hasKey := req.firstKey(r.client)
+		if !c.loadTestScheduler.send(key, hasKey, task, false) {
+			break
+		}
+		c.stats.Count1(pkgName, metricReplayed, client.getTags(tagFunctionSpool))
+		// e.g. a SET replayed after a live DEL of the key resurrects it, the keys are recorded for the repair
+		if c.deadLetterSink != nil {
+			c.deadLetter(ctx, client, req, errSpoolReplayedLate)
+		}
+		committed = ends[i]
+	}
+
+	if committed < 0 {
+		return
+	}
+	if err := c.spool.commit(committed); err != nil {
+		c.logger.Warn(pkgName, "failed to truncate spool, Error: %s", err)
+	}
+}
@@ -0,0 +1,189 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	goredis "github.com/grab/redis/v8"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/grab/grab-redis/redisapi"
+)
+
+var _ = Describe("Test Spool", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "spool")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	It("serializes the requests", func() {
+		req := newRunRequest(redisapi.NewScript(1, "return 1"), []interface{}{"key", 1, []byte{0xff}})
+		restored := newSpoolRecord("load-test", req).request()
+		Expect(restored.function).To(Equal(tagFunctionRun))
+		Expect(restored.script.Source()).To(Equal("return 1"))
+		Expect(restored.script.KeyCount()).To(Equal(1))
+		Expect(restored.keysAndArgs).To(Equal([]interface{}{[]byte("key"), []byte("1"), []byte{0xff}}))
+
+		restored = newSpoolRecord("load-test", newDoRequest("SET", []interface{}{"k", "v"})).request()
+		Expect(restored.cmds).To(Equal([][]interface{}{{"SET", []byte("k"), []byte("v")}}))
+	})
+
+	It("reads, commits and truncates the records", func() {
+		s, err := newSpool(dir, 1<<20)
+		Expect(err).NotTo(HaveOccurred())
+		defer s.close()
+
+		for i := 0; i < 3; i++ {
+			Expect(s.append(newSpoolRecord("load-test", newDoRequest("SET", []interface{}{"k", i})))).To(Succeed())
+		}
+
+		records, ends, err := s.read(2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(2))
+		Expect(s.commit(ends[1])).To(Succeed())
+
+		records, ends, err = s.read(2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(1))
+		Expect(records[0].Cmds[0][2]).To(Equal([]byte("2")))
+		Expect(s.commit(ends[0])).To(Succeed())
+		Expect(s.pending()).To(BeZero())
+	})
+
+	It("rejects the records over the max size", func() {
+		s, err := newSpool(dir, 100)
+		Expect(err).NotTo(HaveOccurred())
+		defer s.close()
+
+		Expect(s.append(newSpoolRecord("load-test", newDoRequest("SET", []interface{}{"k", "v"})))).To(Succeed())
+		Expect(s.append(newSpoolRecord("load-test", newDoRequest("SET", []interface{}{"k", "v"})))).To(Equal(errSpoolFull))
+	})
+
+	It("compacts the replayed records out when the file is full", func() {
+		record := func(i int) *spoolRecord {
+			return newSpoolRecord("load-test", newDoRequest("SET", []interface{}{"k", i}))
+		}
+		data, err := json.Marshal(record(0))
+		Expect(err).NotTo(HaveOccurred())
+		s, err := newSpool(dir, 2*int64(len(data)+1))
+		Expect(err).NotTo(HaveOccurred())
+		defer s.close()
+
+		Expect(s.append(record(0))).To(Succeed())
+		Expect(s.append(record(1))).To(Succeed())
+		Expect(s.append(record(2))).To(Equal(errSpoolFull))
+
+		_, ends, err := s.read(1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.commit(ends[0])).To(Succeed())
+		Expect(s.append(record(2))).To(Succeed())
+		Expect(s.pending()).To(Equal(2 * int64(len(data)+1)))
+
+		records, _, err := s.read(2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(2))
+		Expect(records[0].Cmds[0][2]).To(Equal([]byte("1")))
+		Expect(records[1].Cmds[0][2]).To(Equal([]byte("2")))
+	})
+
+	It("spools the overflow and replays it after the backlog drains", func() {
+		stats := newFakeStatsClient()
+		loadTest := &clientImpl{config: &ClientConfig{Addrs: []string{"load-test:6379"}, ClientMode: ModeSingleHost}}
+		sink := &fakeDeadLetterSink{}
+		c := &connectorImpl{
+			loadTestScheduler: newScheduler(&schedulerOptions{maxChanSize: 2}),
+			deadLetterSink:    sink,
+			stats:             stats,
+			logger:            NewNoopLogger(),
+		}
+		c.routing.Store(&routing{
+			client: &clientImpl{
+				config:   &ClientConfig{},
+				cmdCache: map[string]*goredis.CommandInfo{"set": {Name: "set", FirstKeyPos: 1}},
+			},
+			loadTestClients: []*clientImpl{loadTest},
+		})
+		var err error
+		c.spool, err = newSpool(dir, 1<<20)
+		Expect(err).NotTo(HaveOccurred())
+		defer c.spool.close()
+
+		for i := 0; i < 3; i++ {
+			c.queueLoadTest(c.loadRouting(), newDoRequest("SET", []interface{}{"k", i}))
+		}
+		Expect(c.loadTestScheduler.fnChan).To(HaveLen(2))
+		Expect(stats.count(metricSpooled, tagFunctionSpool)).To(Equal(1))
+
+		c.replaySpoolOnce(context.Background())
+		Expect(c.spool.pending()).NotTo(BeZero())
+
+		<-c.loadTestScheduler.fnChan
+		<-c.loadTestScheduler.fnChan
+		c.replaySpoolOnce(context.Background())
+		Expect(c.loadTestScheduler.fnChan).To(HaveLen(1))
+		Expect(c.spool.pending()).To(BeZero())
+		Expect(stats.count(metricReplayed, tagFunctionSpool)).To(Equal(1))
+		// the replayed write is recorded as it may be older than the live writes
+		Expect(sink.letters).To(HaveLen(1))
+		Expect(sink.letters[0].Keys).To(Equal([]string{"k"}))
+		Expect(sink.letters[0].Error).To(Equal(errSpoolReplayedLate.Error()))
+	})
+	It("sends the overflow of an ordered lane to the dead letter sink", func() {
+		sink := &fakeDeadLetterSink{}
+		loadTest := &clientImpl{config: &ClientConfig{Addrs: []string{"load-test:6379"}, ClientMode: ModeSingleHost}}
+		c := &connectorImpl{
+			loadTestScheduler: newScheduler(&schedulerOptions{maxChanSize: 2, maxWorker: 1, orderedByKey: true}),
+			deadLetterSink:    sink,
+			stats:             NewNoopStatsClient(),
+			logger:            NewNoopLogger(),
+		}
+		c.routing.Store(&routing{client: &clientImpl{config: &ClientConfig{}}, loadTestClients: []*clientImpl{loadTest}})
+		var err error
+		c.spool, err = newSpool(dir, 1<<20)
+		Expect(err).NotTo(HaveOccurred())
+		defer c.spool.close()
+
+		for i := 0; i < 3; i++ {
+			c.queueLoadTest(c.loadRouting(), newDoRequest("SET", []interface{}{"k", i}))
+		}
+		Expect(c.loadTestScheduler.backlog()).To(Equal(2))
+		Expect(c.spool.pending()).To(BeZero())
+		Expect(sink.letters).To(HaveLen(1))
+		Expect(sink.letters[0].Error).To(Equal(errLoadTestQueueFull.Error()))
+	})
+})
@@ -0,0 +1,163 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"sync"
+	"time"
+
+	"github.com/grab/grab-redis/redisapi"
+)
+
+// subscribeMirrorClients returns the clients on the other side of the migration to subscribe as well, so the messages
+// published to either cluster are received. The load test clients are not subscribed out of a migration, nobody reads
+// their messages.
+func (c *connectorImpl) subscribeMirrorClients(r *routing) []*clientImpl {
+	if r.phase == "" {
+		return nil
+	}
+
+	var clients []*clientImpl
+	for _, client := range r.mirrorClients() {
+		if isMirrored(r.mirrorRules, client.config.name(), redisSubscribe, "", false) {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// mergeSubscriptions merges the messages of the subscriptions into one channel, a message published through the connector
+// is received from every side but only delivered once. Unsubscribe tears down all the subscriptions.
+func (c *connectorImpl) mergeSubscriptions(bufferSize int, responses []*redisapi.SubscribeResponse) *redisapi.SubscribeResponse {
+	resultChan := make(chan interface{}, bufferSize)
+	done := make(chan struct{})
+	deduper := newMessageDeduper(len(responses), subscribeDedupWindow)
+
+	var wg sync.WaitGroup
+	for i, response := range responses {
+		wg.Add(1)
+		go func(side int, ch <-chan interface{}) {
+			defer wg.Done()
+			// keep draining until the subscription is closed, so its goroutine is not blocked
+			for msg := range ch {
+				if message, ok := msg.(*redisapi.SubscribeMessage); ok && !deduper.deliver(side, message) {
+					c.stats.Count1(pkgName, metricSkipped, c.mainClient().getTags(tagFunctionSubscribe))
+					continue
+				}
+
+				select {
+				case resultChan <- msg:
+				case <-done:
+				}
+			}
+		}(i, response.ResultChan)
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+
+	var once sync.Once
+	return &redisapi.SubscribeResponse{
+		ResultChan: resultChan,
+		Unsubscribe: func() {
+			once.Do(func() {
+				close(done)
+				for _, response := range responses {
+					response.Unsubscribe()
+				}
+			})
+		},
+	}
+}
+
+// messageDeduper drops the copies of a message received from the other sides, a message is delivered when its side has
+// received it more times than it has been delivered, so the same message published twice to one side is delivered twice.
+type messageDeduper struct {
+	mu        sync.Mutex
+	sides     int
+	window    time.Duration
+	seen      map[string]*seenMessage
+	lastPurge time.Time
+}
+
+type seenMessage struct {
+	received  []int
+	delivered int
+	updated   time.Time
+}
+
+func newMessageDeduper(sides int, window time.Duration) *messageDeduper {
+	return &messageDeduper{
+		sides:     sides,
+		window:    window,
+		seen:      make(map[string]*seenMessage),
+		lastPurge: time.Now(),
+	}
+}
+
+// deliver returns whether the message received from the side is delivered
+func (d *messageDeduper) deliver(side int, message *redisapi.SubscribeMessage) bool {
+	now := time.Now()
+	key := message.Channel + "\x00" + string(message.Data)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// the copies not received from every side within the window, e.g. published to one cluster directly, are forgotten
+	if now.Sub(d.lastPurge) > d.window {
+		for k, seen := range d.seen {
+			if now.Sub(seen.updated) > d.window {
+				delete(d.seen, k)
+			}
+		}
+		d.lastPurge = now
+	}
+
+	seen, ok := d.seen[key]
+	if !ok {
+		seen = &seenMessage{received: make([]int, d.sides)}
+		d.seen[key] = seen
+	}
+	seen.received[side]++
+	seen.updated = now
+
+	delivered := seen.received[side] > seen.delivered
+	if delivered {
+		seen.delivered++
+	}
+
+	for _, received := range seen.received {
+		if received != seen.delivered {
+			return delivered
+		}
+	}
+	// every side has received all the copies
+	delete(d.seen, key)
+	return delivered
+}
@@ -0,0 +1,107 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/grab/grab-redis/redisapi"
+)
+
+var _ = Describe("Test Subscribe", func() {
+	message := func(data string) *redisapi.SubscribeMessage {
+		return &redisapi.SubscribeMessage{Channel: "ch", Data: []byte(data)}
+	}
+
+	fakeSubscription := func() (chan interface{}, *redisapi.SubscribeResponse, *bool) {
+		ch := make(chan interface{}, 10)
+		unsubscribed := false
+		return ch, &redisapi.SubscribeResponse{
+			ResultChan: ch,
+			Unsubscribe: func() {
+				unsubscribed = true
+				close(ch)
+			},
+		}, &unsubscribed
+	}
+
+	It("delivers a message once per copy of its side", func() {
+		d := newMessageDeduper(2, time.Minute)
+		Expect(d.deliver(0, message("a"))).To(BeTrue())
+		Expect(d.deliver(1, message("a"))).To(BeFalse())
+		Expect(d.seen).To(BeEmpty())
+
+		// published twice to the same side
+		Expect(d.deliver(1, message("b"))).To(BeTrue())
+		Expect(d.deliver(1, message("b"))).To(BeTrue())
+		Expect(d.deliver(0, message("b"))).To(BeFalse())
+		Expect(d.deliver(0, message("b"))).To(BeFalse())
+		Expect(d.seen).To(BeEmpty())
+	})
+
+	It("forgets the messages only received from one side", func() {
+		d := newMessageDeduper(2, 10*time.Millisecond)
+		Expect(d.deliver(0, message("a"))).To(BeTrue())
+		time.Sleep(20 * time.Millisecond)
+		Expect(d.deliver(0, message("b"))).To(BeTrue())
+		Expect(d.seen).To(HaveLen(1))
+		Expect(d.deliver(1, message("a"))).To(BeTrue())
+	})
+
+	It("merges the subscriptions and tears them down", func() {
+		c := &connectorImpl{stats: NewNoopStatsClient()}
+		c.routing.Store(&routing{client: &clientImpl{config: &ClientConfig{}}})
+		mainChan, main, mainUnsubscribed := fakeSubscription()
+		mirrorChan, mirror, mirrorUnsubscribed := fakeSubscription()
+		merged := c.mergeSubscriptions(10, []*redisapi.SubscribeResponse{main, mirror})
+
+		mainChan <- message("a")
+		Eventually(merged.ResultChan).Should(Receive(Equal(message("a"))))
+		mirrorChan <- message("a")
+		mirrorChan <- message("b")
+		Eventually(merged.ResultChan).Should(Receive(Equal(message("b"))))
+		Consistently(merged.ResultChan, 50*time.Millisecond).ShouldNot(Receive())
+
+		merged.Unsubscribe()
+		merged.Unsubscribe()
+		Expect(*mainUnsubscribed).To(BeTrue())
+		Expect(*mirrorUnsubscribed).To(BeTrue())
+		Eventually(merged.ResultChan).Should(BeClosed())
+	})
+
+	It("doesn't subscribe on the load test clients out of a migration", func() {
+		c := &connectorImpl{}
+		r := &routing{loadTestClients: []*clientImpl{{config: &ClientConfig{}}}}
+		Expect(c.subscribeMirrorClients(r)).To(BeEmpty())
+
+		r.phase = PhaseDualWrite
+		Expect(c.subscribeMirrorClients(r)).To(HaveLen(1))
+	})
+})
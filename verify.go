@@ -0,0 +1,342 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+
+	"github.com/grab/grab-redis/redisapi"
+	goredis "github.com/grab/redis/v8"
+)
+
+// the reasons of a mismatched key
+const (
+	VerifyMissing = "missing"
+	VerifyType    = "type"
+	VerifyValue   = "value"
+	VerifyTTL     = "ttl"
+)
+
+// Verifier compares the type, value and TTL of the keys of the main client with the load test clients
+type Verifier struct {
+	config    *VerifyConfig
+	main      *clientImpl
+	loadTests []*clientImpl
+	limiter   *tokenBucket
+
+	mu     sync.Mutex
+	report *VerifyReport
+
+	stats  StatsClient
+	logger Logger
+}
+
+// VerifyReport is the result of a Verify, the targets are keyed by the name of the load test clients
+type VerifyReport struct {
+	Targets    map[string]*VerifyTargetReport
+	Mismatches []VerifyMismatch
+	// Errors is the number of keys failed to be read from the main client
+	Errors int64
+}
+
+// VerifyTargetReport is the number of the keys checked against a load test client
+type VerifyTargetReport struct {
+	Checked         int64
+	Matched         int64
+	Missing         int64
+	TypeMismatched  int64
+	ValueMismatched int64
+	TTLMismatched   int64
+	Errors          int64
+}
+
+// VerifyMismatch is a key which is different between the main client and a load test client
+type VerifyMismatch struct {
+	Target string
+	Key    string
+	Reason string
+}
+
+// IsConsistent returns true if at least one key is checked and every checked key matches on every load test client,
+// which is the signal of safe to cut over
+func (r *VerifyReport) IsConsistent() bool {
+	if r.Errors > 0 {
+		return false
+	}
+	for _, target := range r.Targets {
+		if target.Checked == 0 || target.Matched != target.Checked {
+			return false
+		}
+	}
+	return true
+}
+
+// NewVerifier creates the main and load test clients of the verify, the options are applied to all the clients.
+func NewVerifier(ctx context.Context, config *VerifyConfig, options ...ClientOption) (*Verifier, error) {
+	config.init()
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	main, err := newClient(ctx, config.Main, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Verifier{
+		config:  config,
+		main:    main,
+		limiter: newTokenBucket(float64(config.MaxKeysPerSecond), config.ScanCount),
+		stats:   main.stats,
+		logger:  main.logger,
+	}
+
+	for _, loadTestConfig := range config.LoadTests {
+		client, err := newClient(ctx, loadTestConfig, options...)
+		if err != nil {
+			v.ShutDown(ctx)
+			return nil, err
+		}
+		v.loadTests = append(v.loadTests, client)
+	}
+
+	return v, nil
+}
+
+// Verify compares the sampled keys, or all the keys if SampleSize is 0, of every master node of the main client with the load test clients.
+func (v *Verifier) Verify(ctx context.Context) (*VerifyReport, error) {
+	v.report = &VerifyReport{Targets: make(map[string]*VerifyTargetReport)}
+	for _, client := range v.loadTests {
+		v.report.Targets[client.config.name()] = &VerifyTargetReport{}
+	}
+
+	var err error
+	if v.config.SampleSize > 0 {
+		err = v.verifySamples(ctx)
+	} else {
+		err = v.main.wrappedClient.forEachMaster(ctx, v.verifyNode)
+	}
+	v.reportMetrics()
+
+	return v.report, err
+}
+
+// ShutDown closes the main and load test clients
+func (v *Verifier) ShutDown(ctx context.Context) {
+	v.main.ShutDown(ctx)
+	for _, client := range v.loadTests {
+		client.ShutDown(ctx)
+	}
+}
+
+func (v *Verifier) verifySamples(ctx context.Context) error {
+	masters := atomic.NewInt64(0)
+	_ = v.main.wrappedClient.forEachMaster(ctx, func(ctx context.Context, node *goredis.Client) error {
+		masters.Inc()
+		return nil
+	})
+	if masters.Load() == 0 {
+		return errors.New("no master node found for verify")
+	}
+
+	// spread the samples evenly among the master nodes
+	samplesPerNode := (int64(v.config.SampleSize) + masters.Load() - 1) / masters.Load()
+	return v.main.wrappedClient.forEachMaster(ctx, func(ctx context.Context, node *goredis.Client) error {
+		for i := int64(0); i < samplesPerNode; i++ {
+			key, err := redisapi.String(nodeDoer(node)(ctx, "RANDOMKEY"))
+			if err == redisapi.ErrNoData {
+				// the node is empty
+				return nil
+			}
+			if err != nil {
+				return errors.Wrapf(err, "verify RANDOMKEY on node %s failed", node.Options().Addr)
+			}
+
+			if err = v.verifyKey(ctx, node, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (v *Verifier) verifyNode(ctx context.Context, node *goredis.Client) error {
+	var cursor uint64
+	for {
+		keys, next, err := node.Scan(ctx, cursor, v.config.Match, int64(v.config.ScanCount)).Result()
+		if err != nil {
+			return errors.Wrapf(err, "verify scan on node %s failed", node.Options().Addr)
+		}
+
+		for _, key := range keys {
+			if err = v.verifyKey(ctx, node, key); err != nil {
+				return err
+			}
+		}
+
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// verifyKey compares a key of the main node with all the load test clients, only the ctx error is returned
+func (v *Verifier) verifyKey(ctx context.Context, node *goredis.Client, key string) error {
+	if err := v.limiter.wait(ctx); err != nil {
+		return err
+	}
+
+	mainType, mainValue, err := readKey(ctx, nodeDoer(node), key)
+	if err == nil && mainType == "none" {
+		// the key is deleted or expired after SCAN
+		return nil
+	}
+	var mainTTL int64
+	if err == nil {
+		mainTTL, err = redisapi.Int64(nodeDoer(node)(ctx, "PTTL", key))
+	}
+	if err != nil {
+		v.mu.Lock()
+		v.report.Errors++
+		v.mu.Unlock()
+		v.logger.Warn(pkgName, "verify of key %s failed on main client, Error: %s", key, err)
+		return nil
+	}
+
+	for _, client := range v.loadTests {
+		// the key is compared with the key written by the key rewrite rules of the load test client
+		targetKey := client.config.rewriteKey(key)
+		keyType, value, err := readKey(ctx, clientDoer(client), targetKey)
+		var ttl int64
+		if err == nil {
+			ttl, err = redisapi.Int64(client.do(ctx, "PTTL", targetKey))
+		}
+
+		var reason string
+		if err == nil {
+			reason = compareKey(mainType, mainValue, mainTTL, keyType, value, ttl, int64(v.config.TTLToleranceInMs))
+		}
+		v.addResult(client.config.name(), key, reason, err)
+	}
+
+	return nil
+}
+
+func (v *Verifier) addResult(target string, key string, reason string, err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	report := v.report.Targets[target]
+	report.Checked++
+	switch {
+	case err != nil:
+		report.Errors++
+		v.logger.Warn(pkgName, "verify of key %s failed on load test client %s, Error: %s", key, target, err)
+		return
+	case reason == "":
+		report.Matched++
+		return
+	case reason == VerifyMissing:
+		report.Missing++
+	case reason == VerifyType:
+		report.TypeMismatched++
+	case reason == VerifyValue:
+		report.ValueMismatched++
+	case reason == VerifyTTL:
+		report.TTLMismatched++
+	}
+
+	if len(v.report.Mismatches) < v.config.MaxReportedMismatches {
+		v.report.Mismatches = append(v.report.Mismatches, VerifyMismatch{Target: target, Key: key, Reason: reason})
+	}
+}
+
+// compareKey returns the reason of the mismatch, or empty if the keys are the same
+func compareKey(mainType string, mainValue interface{}, mainTTL int64, keyType string, value interface{}, ttl int64, ttlTolerance int64) string {
+	if keyType == "none" {
+		return VerifyMissing
+	}
+	if mainType != keyType {
+		return VerifyType
+	}
+
+	var equal bool
+	switch mainType {
+	case "string", "list":
+		equal = reflect.DeepEqual(mainValue, value)
+	case "set":
+		equal = isReplyEqual(mainValue, value, true)
+	case "hash", "zset":
+		equal = reflect.DeepEqual(pairsToMap(mainValue), pairsToMap(value))
+	}
+	if !equal {
+		return VerifyValue
+	}
+
+	// -1 means the key has no TTL
+	if (mainTTL < 0) != (ttl < 0) {
+		return VerifyTTL
+	}
+	diff := mainTTL - ttl
+	if diff < 0 {
+		diff = -diff
+	}
+	if mainTTL >= 0 && diff > ttlTolerance {
+		return VerifyTTL
+	}
+
+	return ""
+}
+
+// pairsToMap converts the reply of HGETALL or ZRANGE WITHSCORES to a map, so the order of the pairs is ignored
+func pairsToMap(value interface{}) map[string]string {
+	values, _ := redisapi.Strings(value, nil)
+	result := make(map[string]string, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		result[values[i]] = values[i+1]
+	}
+	return result
+}
+
+func (v *Verifier) reportMetrics() {
+	for _, client := range v.loadTests {
+		report := v.report.Targets[client.config.name()]
+		v.stats.Gauge("redis.verify", metricChecked, float64(report.Checked), client.getTags())
+		v.stats.Gauge("redis.verify", metricMatch, float64(report.Matched), client.getTags())
+		v.stats.Gauge("redis.verify", metricMismatch, float64(report.Missing), client.getTags(tagReasonPrefix+VerifyMissing))
+		v.stats.Gauge("redis.verify", metricMismatch, float64(report.TypeMismatched), client.getTags(tagReasonPrefix+VerifyType))
+		v.stats.Gauge("redis.verify", metricMismatch, float64(report.ValueMismatched), client.getTags(tagReasonPrefix+VerifyValue))
+		v.stats.Gauge("redis.verify", metricMismatch, float64(report.TTLMismatched), client.getTags(tagReasonPrefix+VerifyTTL))
+		v.stats.Gauge("redis.verify", metricError, float64(report.Errors), client.getTags())
+	}
+}
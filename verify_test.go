@@ -0,0 +1,156 @@
+// MIT License
+//
+//
+// Copyright 2023 Grabtaxi Holdings Pte Ltd (GRAB), All rights reserved.
+//
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package redis
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	goredis "github.com/grab/redis/v8"
+)
+
+func verifyConfig(sampleSize int) *VerifyConfig {
+	config := clusterConfig()
+	return &VerifyConfig{
+		Main:       config.Main,
+		LoadTests:  config.LoadTests,
+		SampleSize: sampleSize,
+	}
+}
+
+var _ = Describe("Test Verifier", func() {
+	var main *clientImpl
+	var loadTest *clientImpl
+
+	BeforeEach(func() {
+		var err error
+		main, err = newClient(context.Background(), clusterConfig().Main)
+		Expect(err).NotTo(HaveOccurred())
+		loadTest, err = newClient(context.Background(), clusterConfig().LoadTests[0])
+		Expect(err).NotTo(HaveOccurred())
+
+		args := [][]interface{}{
+			{"SET", "string", "value", "PX", 100000},
+			{"HSET", "hash", "f1", "v1", "f2", "v2"},
+			{"SADD", "set", "a", "b"},
+		}
+		for _, client := range []*clientImpl{main, loadTest} {
+			_ = client.wrappedClient.forEachMaster(context.Background(), func(ctx context.Context, client *goredis.Client) error {
+				return client.FlushAll(ctx).Err()
+			})
+			_, err = client.Pipeline(context.Background(), args)
+			Expect(err).NotTo(HaveOccurred())
+		}
+	})
+
+	AfterEach(func() {
+		main.ShutDown(context.Background())
+		loadTest.ShutDown(context.Background())
+	})
+
+	It("reports consistent when the clusters are the same", func() {
+		verifier, err := NewVerifier(context.Background(), verifyConfig(0))
+		Expect(err).NotTo(HaveOccurred())
+		defer verifier.ShutDown(context.Background())
+
+		report, err := verifier.Verify(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.IsConsistent()).To(BeTrue())
+		Expect(report.Targets[loadTestAddr].Checked).To(Equal(int64(3)))
+	})
+
+	It("reports the mismatched keys", func() {
+		_, err := loadTest.Pipeline(context.Background(), [][]interface{}{
+			{"SET", "string", "value"},
+			{"HSET", "hash", "f2", "v3"},
+			{"DEL", "set"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		verifier, err := NewVerifier(context.Background(), verifyConfig(0))
+		Expect(err).NotTo(HaveOccurred())
+		defer verifier.ShutDown(context.Background())
+
+		report, err := verifier.Verify(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.IsConsistent()).To(BeFalse())
+		target := report.Targets[loadTestAddr]
+		Expect(target.TTLMismatched).To(Equal(int64(1)))
+		Expect(target.ValueMismatched).To(Equal(int64(1)))
+		Expect(target.Missing).To(Equal(int64(1)))
+		Expect(report.Mismatches).To(HaveLen(3))
+	})
+
+	It("compares the keys rewritten by the rules of the load test client", func() {
+		_ = loadTest.wrappedClient.forEachMaster(context.Background(), func(ctx context.Context, client *goredis.Client) error {
+			return client.FlushAll(ctx).Err()
+		})
+		_, err := loadTest.Pipeline(context.Background(), [][]interface{}{
+			{"SET", "new:string", "value", "PX", 100000},
+			{"HSET", "new:hash", "f1", "v1", "f2", "v2"},
+			{"SADD", "new:set", "a", "b"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		config := verifyConfig(0)
+		config.LoadTests[0].KeyRewriteRules = []*KeyRewriteRule{{Action: RewriteAddPrefix, Prefix: "new:"}}
+		verifier, err := NewVerifier(context.Background(), config)
+		Expect(err).NotTo(HaveOccurred())
+		defer verifier.ShutDown(context.Background())
+
+		report, err := verifier.Verify(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.IsConsistent()).To(BeTrue())
+	})
+
+	It("samples the keys", func() {
+		verifier, err := NewVerifier(context.Background(), verifyConfig(2))
+		Expect(err).NotTo(HaveOccurred())
+		defer verifier.ShutDown(context.Background())
+
+		report, err := verifier.Verify(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.IsConsistent()).To(BeTrue())
+		Expect(report.Targets[loadTestAddr].Checked).To(BeNumerically(">=", 2))
+	})
+})
+
+var _ = Describe("compareKey", func() {
+	It("compares type, value and ttl", func() {
+		hash := []interface{}{"f1", "v1", "f2", "v2"}
+		reordered := []interface{}{"f2", "v2", "f1", "v1"}
+		Expect(compareKey("hash", hash, -1, "hash", reordered, -1, 1000)).To(BeEmpty())
+		Expect(compareKey("hash", hash, -1, "none", nil, -2, 1000)).To(Equal(VerifyMissing))
+		Expect(compareKey("hash", hash, -1, "string", "v", -1, 1000)).To(Equal(VerifyType))
+		Expect(compareKey("string", "a", -1, "string", "b", -1, 1000)).To(Equal(VerifyValue))
+		Expect(compareKey("string", "a", 5000, "string", "a", 4500, 1000)).To(BeEmpty())
+		Expect(compareKey("string", "a", 5000, "string", "a", 3000, 1000)).To(Equal(VerifyTTL))
+		Expect(compareKey("string", "a", 5000, "string", "a", -1, 1000)).To(Equal(VerifyTTL))
+	})
+})
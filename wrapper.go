@@ -39,6 +39,8 @@ import (
 type clientWrapper interface {
 	redisWrapper
 	reload(config *ClientConfig, cbOption []cb.Option) error
+	// forEachMaster calls the fn on each master node concurrently, it's called once for a single host.
+	forEachMaster(ctx context.Context, fn func(ctx context.Context, client *goredis.Client) error) error
 }
 
 type redisWrapper interface {